@@ -3,6 +3,7 @@ package cephdriver
 import (
 	"io"
 	"os"
+	"os/exec"
 	"strings"
 	. "testing"
 
@@ -66,6 +67,56 @@ func (s *cephSuite) TestMountUnmountVolume(c *C) {
 	c.Assert(volumeSpec.Remove(), IsNil)
 }
 
+func (s *cephSuite) TestCreateRollback(c *C) {
+	volumeSpec := NewCephDriver().NewVolume("rbd", "pithos1234", 10)
+	volumeSpec.Remove()
+
+	c.Assert(volumeSpec.Create("false %"), NotNil)
+
+	ok, err := volumeSpec.Exists()
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+
+	// the failed create left nothing behind, so it can be retried.
+	c.Assert(volumeSpec.Create("mkfs.ext4 -m0 %"), IsNil)
+	c.Assert(volumeSpec.Remove(), IsNil)
+}
+
+func (s *cephSuite) TestMountUnmountVolumeNBD(c *C) {
+	volumeSpec := NewCephDriver().NewVolume("rbd", "pithos1234", 10)
+	volumeSpec.MapMode = MapModeNBD
+
+	volumeSpec.Unmount()
+	volumeSpec.Remove()
+
+	c.Assert(volumeSpec.Create("mkfs.ext4 -m0 %"), IsNil)
+	ms, err := volumeSpec.Mount("ext4")
+	c.Assert(err, IsNil)
+	c.Assert(ms.DevMajor, Equals, uint(43))
+	c.Assert(strings.HasPrefix(ms.DeviceName, "/dev/nbd"), Equals, true)
+	s.readWriteTest(c, "/mnt/ceph/rbd/pithos1234")
+	c.Assert(volumeSpec.Unmount(), IsNil)
+	c.Assert(volumeSpec.Remove(), IsNil)
+}
+
+func (s *cephSuite) TestMountReadOnly(c *C) {
+	volumeSpec := NewCephDriver().NewVolume("rbd", "pithos1234", 10)
+
+	volumeSpec.Unmount()
+	volumeSpec.Remove()
+
+	c.Assert(volumeSpec.Create("mkfs.ext4 -m0 %"), IsNil)
+	defer volumeSpec.Remove()
+
+	volumeSpec.ReadOnly = true
+	_, err := volumeSpec.Mount("ext4")
+	c.Assert(err, IsNil)
+
+	_, err = os.Create("/mnt/ceph/rbd/pithos1234/test.txt")
+	c.Assert(err, NotNil)
+	c.Assert(volumeSpec.Unmount(), IsNil)
+}
+
 func (s *cephSuite) TestSnapshots(c *C) {
 	volumeSpec := NewCephDriver().NewVolume("rbd", "pithos1234", 10)
 	c.Assert(volumeSpec.Create("mkfs.ext4 -m0 %"), IsNil)
@@ -87,6 +138,45 @@ func (s *cephSuite) TestSnapshots(c *C) {
 	c.Assert(volumeSpec.Remove(), IsNil)
 }
 
+func (s *cephSuite) TestTrim(c *C) {
+	volumeSpec := NewCephDriver().NewVolume("rbd", "pithos1234", 10)
+	c.Assert(volumeSpec.Create("mkfs.ext4 -m0 %"), IsNil)
+	defer volumeSpec.Remove()
+
+	_, err := volumeSpec.Trim()
+	c.Assert(err, NotNil)
+
+	_, err = volumeSpec.Mount("ext4")
+	c.Assert(err, IsNil)
+	defer volumeSpec.Unmount()
+
+	s.readWriteTest(c, "/mnt/ceph/rbd/pithos1234")
+	c.Assert(os.Remove("/mnt/ceph/rbd/pithos1234/test.txt"), IsNil)
+
+	trimmed, err := volumeSpec.Trim()
+	c.Assert(err, IsNil)
+	c.Assert(trimmed > 0, Equals, true)
+}
+
+func (s *cephSuite) TestFreezeThaw(c *C) {
+	volumeSpec := NewCephDriver().NewVolume("rbd", "pithos1234", 10)
+	c.Assert(volumeSpec.Create("mkfs.ext4 -m0 %"), IsNil)
+	defer volumeSpec.Remove()
+
+	c.Assert(volumeSpec.Freeze(), NotNil)
+
+	_, err := volumeSpec.Mount("ext4")
+	c.Assert(err, IsNil)
+	defer volumeSpec.Unmount()
+
+	c.Assert(volumeSpec.Freeze(), IsNil)
+	c.Assert(volumeSpec.CreateSnapshot("frozen"), IsNil)
+	c.Assert(volumeSpec.Thaw(), IsNil)
+	c.Assert(volumeSpec.Thaw(), NotNil)
+
+	s.readWriteTest(c, "/mnt/ceph/rbd/pithos1234")
+}
+
 func (s *cephSuite) TestRepeatedMountUnmount(c *C) {
 	volumeSpec := NewCephDriver().NewVolume("rbd", "pithos1234", 10)
 	c.Assert(volumeSpec.Create("mkfs.ext4 -m0 %"), IsNil)
@@ -99,6 +189,73 @@ func (s *cephSuite) TestRepeatedMountUnmount(c *C) {
 	c.Assert(volumeSpec.Remove(), IsNil)
 }
 
+func (s *cephSuite) TestInspectAndRename(c *C) {
+	volumeSpec := NewCephDriver().NewVolume("rbd", "pithos1234", 10)
+	c.Assert(volumeSpec.Create("mkfs.ext4 -m0 %"), IsNil)
+
+	size, err := volumeSpec.Size()
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, uint64(10))
+
+	fs, err := volumeSpec.FileSystem()
+	c.Assert(err, IsNil)
+	c.Assert(fs, Equals, "ext4")
+
+	c.Assert(volumeSpec.Rename("pithos5678"), IsNil)
+	c.Assert(volumeSpec.VolumeName, Equals, "pithos5678")
+
+	ok, err := volumeSpec.Exists()
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+
+	c.Assert(volumeSpec.Remove(), IsNil)
+}
+
+func (s *cephSuite) TestCopy(c *C) {
+	// the pool may already exist from a previous run.
+	exec.Command("ceph", "osd", "pool", "create", "rbd2", "64").Run()
+
+	volumeSpec := NewCephDriver().NewVolume("rbd", "pithos1234", 10)
+	c.Assert(volumeSpec.Create("mkfs.ext4 -m0 %"), IsNil)
+	defer volumeSpec.Remove()
+	c.Assert(volumeSpec.CreateSnapshot("hello"), IsNil)
+
+	copied, err := volumeSpec.Copy("rbd2", true)
+	c.Assert(err, IsNil)
+	defer copied.Remove()
+	c.Assert(copied.PoolName, Equals, "rbd2")
+
+	list, err := copied.ListSnapshots()
+	c.Assert(err, IsNil)
+	c.Assert(list, DeepEquals, []string{"hello"})
+
+	for _, snap := range []string{"", "hello"} {
+		sum, err := volumeSpec.Checksum(snap)
+		c.Assert(err, IsNil)
+
+		copiedSum, err := copied.Checksum(snap)
+		c.Assert(err, IsNil)
+		c.Assert(copiedSum, Equals, sum)
+	}
+}
+
+func (s *cephSuite) TestParseMapped(c *C) {
+	mapped, err := parseMapped([]byte(`{"0":{"pool":"rbd","name":"pithos1234","snap":"-","device":"/dev/rbd0"}}`))
+	c.Assert(err, IsNil)
+	c.Assert(mapped, DeepEquals, []mappedImage{{Pool: "rbd", Name: "pithos1234", Device: "/dev/rbd0"}})
+
+	mapped, err = parseMapped([]byte(`[{"id":"31401","pool":"rbd","namespace":"","image":"pithos1234","snap":"-","device":"/dev/nbd0"}]`))
+	c.Assert(err, IsNil)
+	c.Assert(mapped, DeepEquals, []mappedImage{{Pool: "rbd", Image: "pithos1234", Device: "/dev/nbd0"}})
+
+	mapped, err = parseMapped([]byte("\n"))
+	c.Assert(err, IsNil)
+	c.Assert(mapped, HasLen, 0)
+
+	_, err = parseMapped([]byte("id pool image snap device"))
+	c.Assert(err, NotNil)
+}
+
 func (s *cephSuite) TestTemplateFSCmd(c *C) {
 	c.Assert(templateFSCmd("%", "foo"), Equals, "foo")
 	c.Assert(templateFSCmd("%%", "foo"), Equals, "%%")
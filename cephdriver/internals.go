@@ -1,21 +1,63 @@
 package cephdriver
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os/exec"
-	"regexp"
 	"strconv"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
 )
 
+// mappedImage is a single entry of the mapped device listing returned by `rbd
+// showmapped` or `rbd-nbd list-mapped`. The tools disagree on the name of the
+// image field.
+type mappedImage struct {
+	Pool   string `json:"pool"`
+	Name   string `json:"name"`
+	Image  string `json:"image"`
+	Device string `json:"device"`
+}
+
 func (cv *CephVolume) volumeCreate() error {
 	return exec.Command("rbd", "create", cv.VolumeName, "--size", strconv.FormatUint(cv.VolumeSize, 10), "--pool", cv.PoolName).Run()
 }
 
+// undoCreate unmaps the image if it is mapped, and removes it, after Create
+// failed part way. Failures are logged rather than returned, so that the
+// error which failed Create is the one reported.
+func (cv *CephVolume) undoCreate(mapped bool) {
+	if mapped {
+		if err := cv.unmapImage(); err != nil {
+			log.Errorf("Could not unmap image %s/%s after a failed create: %v", cv.PoolName, cv.VolumeName, err)
+			return
+		}
+	}
+
+	if err := cv.Remove(); err != nil {
+		log.Errorf("Could not remove image %s/%s after a failed create: %v", cv.PoolName, cv.VolumeName, err)
+	}
+}
+
 func (cv *CephVolume) mapImage() (string, error) {
-	blkdev, err := exec.Command("rbd", "map", cv.VolumeName, "--pool", cv.PoolName).Output()
+	var args []string
+
+	switch cv.MapMode {
+	case MapModeNBD:
+		args = []string{"rbd-nbd", "map", cv.PoolName + "/" + cv.VolumeName}
+	default:
+		args = []string{"rbd", "map", cv.VolumeName, "--pool", cv.PoolName}
+	}
+
+	if cv.ReadOnly {
+		args = append(args, "--read-only")
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+
+	blkdev, err := cmd.Output()
 	device := strings.TrimSpace(string(blkdev))
 
 	if err == nil {
@@ -38,26 +80,38 @@ func (cd *CephDriver) mkfsVolume(fscmd, devicePath string) error {
 }
 
 func (cv *CephVolume) unmapImage() error {
-	output, err := exec.Command("rbd", "showmapped", "--pool", cv.PoolName).Output()
+	var (
+		output []byte
+		err    error
+		unmap  = "rbd"
+	)
+
+	switch cv.MapMode {
+	case MapModeNBD:
+		output, err = exec.Command("rbd-nbd", "list-mapped", "--format", "json").Output()
+		unmap = "rbd-nbd"
+	default:
+		output, err = exec.Command("rbd", "showmapped", "--format", "json").Output()
+	}
+
 	if err != nil {
 		return err
 	}
 
-	lines := strings.Split(string(output), "\n")
-	if len(lines) < 2 {
-		return nil // no mapped images
+	mapped, err := parseMapped(output)
+	if err != nil {
+		return err
 	}
 
-	// the first line is a header
-	for _, line := range lines[1 : len(lines)-1] {
-		parts := regexp.MustCompile(`\s+`).Split(line, -1)
-		pool := parts[1]
-		image := parts[2]
-		device := parts[4]
+	for _, image := range mapped {
+		name := image.Name
+		if name == "" {
+			name = image.Image
+		}
 
-		if strings.TrimSpace(pool) == cv.PoolName && strings.TrimSpace(image) == cv.VolumeName {
-			log.Debugf("Unmapping volume %s/%s at device %q", cv.PoolName, cv.VolumeName, strings.TrimSpace(device))
-			if err := exec.Command("rbd", "unmap", device).Run(); err != nil {
+		if image.Pool == cv.PoolName && name == cv.VolumeName {
+			log.Debugf("Unmapping volume %s/%s at device %q", cv.PoolName, cv.VolumeName, image.Device)
+			if err := exec.Command(unmap, "unmap", image.Device).Run(); err != nil {
 				return err
 			}
 		}
@@ -65,3 +119,28 @@ func (cv *CephVolume) unmapImage() error {
 
 	return nil
 }
+
+// parseMapped parses the JSON listing of mapped images. Depending on the ceph
+// release this is either an array or an object keyed by device id.
+func parseMapped(output []byte) ([]mappedImage, error) {
+	output = bytes.TrimSpace(output)
+	if len(output) == 0 {
+		return nil, nil // no mapped images
+	}
+
+	mapped := []mappedImage{}
+	if err := json.Unmarshal(output, &mapped); err == nil {
+		return mapped, nil
+	}
+
+	byID := map[string]mappedImage{}
+	if err := json.Unmarshal(output, &byID); err != nil {
+		return nil, fmt.Errorf("Could not parse mapped images: %v", err)
+	}
+
+	for _, image := range byID {
+		mapped = append(mapped, image)
+	}
+
+	return mapped, nil
+}
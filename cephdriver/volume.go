@@ -1,22 +1,47 @@
 package cephdriver
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"syscall"
+	"unsafe"
 
 	"golang.org/x/sys/unix"
 )
 
+// fitrim is the FITRIM ioctl, _IOWR('X', 121, struct fstrim_range).
+const fitrim = 0xC0185879
+
+// fstrimRange mirrors struct fstrim_range from linux/fs.h.
+type fstrimRange struct {
+	start  uint64
+	length uint64
+	minlen uint64
+}
+
+const (
+	// MapModeKRBD maps images with the kernel rbd client. This is the default.
+	MapModeKRBD = "krbd"
+	// MapModeNBD maps images with rbd-nbd, for kernels whose rbd client does
+	// not support the features of the image.
+	MapModeNBD = "nbd"
+)
+
 // CephVolume is a struct that communicates volume name and size.
 type CephVolume struct {
 	VolumeName string // Name of the volume
 	PoolName   string
 	VolumeSize uint64 // Size in MBs
+	MapMode    string // MapModeKRBD or MapModeNBD; empty means krbd
+	ReadOnly   bool   // map the image read-only and mount it ro
 	driver     *CephDriver
 }
 
@@ -51,7 +76,8 @@ func (cv *CephVolume) Exists() (bool, error) {
 	return false, nil
 }
 
-// Create creates an RBD image and initialize ext4 filesystem on the image
+// Create creates an RBD image and initialize ext4 filesystem on the image. If
+// creating the filesystem fails, the image is removed again.
 func (cv *CephVolume) Create(fscmd string) error {
 	ok, err := cv.driver.PoolExists(cv.PoolName)
 	if err != nil {
@@ -72,16 +98,21 @@ func (cv *CephVolume) Create(fscmd string) error {
 		return err
 	}
 
+	// from here on, a failed step undoes the steps before it, so that an
+	// image without a filesystem is not mistaken for a created one on retry.
 	blkdev, err := cv.mapImage()
 	if err != nil {
+		cv.undoCreate(false)
 		return err
 	}
 
 	if err := cv.driver.mkfsVolume(fscmd, blkdev); err != nil {
+		cv.undoCreate(true)
 		return err
 	}
 
 	if err := cv.unmapImage(); err != nil {
+		cv.undoCreate(true)
 		return err
 	}
 
@@ -119,11 +150,18 @@ func (cv *CephVolume) Mount(fstype string) (*CephMount, error) {
 
 	rdev := fi.Sys().(*syscall.Stat_t).Rdev
 
-	major := rdev >> 8
-	minor := rdev & 0xFF
+	// this is the glibc encoding; nbd devices may have minor numbers
+	// beyond 255 when many are in use.
+	major := (rdev >> 8) & 0xFFF
+	minor := (rdev & 0xFF) | ((rdev >> 12) & 0xFFF00)
+
+	var flags uintptr
+	if cv.ReadOnly {
+		flags |= unix.MS_RDONLY
+	}
 
 	// Mount the RBD
-	if err := unix.Mount(devName, volumeDir, fstype, 0, ""); err != nil && err != unix.EBUSY {
+	if err := unix.Mount(devName, volumeDir, fstype, flags, ""); err != nil && err != unix.EBUSY {
 		return nil, fmt.Errorf("Failed to mount RBD dev %q: %v", devName, err.Error())
 	}
 
@@ -219,3 +257,144 @@ func (cv *CephVolume) ListSnapshots() ([]string, error) {
 
 	return names, nil
 }
+
+// Size returns the provisioned size of the image in MB.
+func (cv *CephVolume) Size() (uint64, error) {
+	out, err := exec.Command("rbd", "info", cv.VolumeName, "--pool", cv.PoolName, "--format", "json").Output()
+	if err != nil {
+		return 0, err
+	}
+
+	info := struct {
+		Size uint64 `json:"size"`
+	}{}
+
+	if err := json.Unmarshal(out, &info); err != nil {
+		return 0, err
+	}
+
+	return info.Size / (1024 * 1024), nil
+}
+
+// FileSystem maps the image and determines the filesystem type present on
+// it. The image is unmapped before returning.
+func (cv *CephVolume) FileSystem() (string, error) {
+	blkdev, err := cv.mapImage()
+	if err != nil {
+		return "", err
+	}
+
+	defer cv.unmapImage()
+
+	out, err := exec.Command("blkid", "-o", "value", "-s", "TYPE", blkdev).Output()
+	if err != nil {
+		return "", fmt.Errorf("Could not determine filesystem on %q: %v", blkdev, err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Rename renames the image within its pool. The volume struct reflects the
+// new name on success.
+func (cv *CephVolume) Rename(newName string) error {
+	if err := exec.Command("rbd", "rename", cv.VolumeName, newName, "--pool", cv.PoolName).Run(); err != nil {
+		return err
+	}
+
+	cv.VolumeName = newName
+	return nil
+}
+
+// Copy copies the image into the supplied pool, keeping its name. If
+// snapshots is true, the snapshots of the image are copied as well. The new
+// volume is returned.
+func (cv *CephVolume) Copy(poolName string, snapshots bool) (*CephVolume, error) {
+	args := []string{"cp"}
+	if snapshots {
+		args = []string{"deep", "cp"}
+	}
+
+	args = append(args, cv.PoolName+"/"+cv.VolumeName, poolName+"/"+cv.VolumeName)
+
+	if out, err := exec.Command("rbd", args...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("Could not copy %s/%s to pool %q: %v (%s)", cv.PoolName, cv.VolumeName, poolName, err, strings.TrimSpace(string(out)))
+	}
+
+	return cv.driver.NewVolume(poolName, cv.VolumeName, cv.VolumeSize), nil
+}
+
+// Checksum returns the SHA-256 of the contents of the image, or of its
+// snapshot snapName if that is not empty.
+func (cv *CephVolume) Checksum(snapName string) (string, error) {
+	spec := cv.PoolName + "/" + cv.VolumeName
+	if snapName != "" {
+		spec += "@" + snapName
+	}
+
+	cmd := exec.Command("rbd", "export", "--no-progress", spec, "-")
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	_, copyErr := io.Copy(hash, out)
+
+	if err := cmd.Wait(); err != nil {
+		return "", fmt.Errorf("Could not export %s: %v", spec, err)
+	}
+
+	if copyErr != nil {
+		return "", fmt.Errorf("Could not read the export of %s: %v", spec, copyErr)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Trim issues discards for the unused blocks of the mounted filesystem,
+// returning the image's space to the cluster. The number of bytes trimmed, as
+// reported by the filesystem, is returned.
+func (cv *CephVolume) Trim() (uint64, error) {
+	mountPath := cv.driver.MountPath(cv.PoolName, cv.VolumeName)
+
+	f, err := os.Open(mountPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := fstrimRange{length: ^uint64(0)}
+
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fitrim, uintptr(unsafe.Pointer(&r))); errno != 0 {
+		return 0, fmt.Errorf("Failed to trim %q: %v", mountPath, errno)
+	}
+
+	return r.length, nil
+}
+
+// Freeze suspends writes to the mounted filesystem and flushes it to the
+// image, so that a snapshot taken afterwards is consistent. Thaw must be
+// called to resume writes.
+func (cv *CephVolume) Freeze() error {
+	mountPath := cv.driver.MountPath(cv.PoolName, cv.VolumeName)
+	if out, err := exec.Command("fsfreeze", "--freeze", mountPath).CombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to freeze %q: %v (%s)", mountPath, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// Thaw resumes writes to a filesystem suspended by Freeze.
+func (cv *CephVolume) Thaw() error {
+	mountPath := cv.driver.MountPath(cv.PoolName, cv.VolumeName)
+	if out, err := exec.Command("fsfreeze", "--unfreeze", mountPath).CombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to thaw %q: %v (%s)", mountPath, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
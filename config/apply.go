@@ -0,0 +1,220 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// OptionChange is the change to a single option of a volume, keyed by its
+// merge key.
+type OptionChange struct {
+	Key string
+	Old string
+	New string
+}
+
+// VolumeChange is the set of option changes PlanTenantApply computed for a
+// volume. It is applied with ApplyVolumeChanges, which refuses to apply it if
+// the volume was modified in the meantime. Changes with Skipped set are not
+// applied at all; Skipped says why.
+type VolumeChange struct {
+	Volume  string
+	Changes []OptionChange
+	Skipped string
+
+	config   *VolumeConfig
+	revision uint64
+}
+
+// PlanTenantApply computes, for each volume of the tenant, the changes needed
+// to bring the named fields of its options in line with the tenant's current
+// default options, overlaid with the volume's profile. Fields are the JSON
+// names of the top-level options, such as "rate-limit" or "snapshot". Options
+// recorded as overridden by the volume are left alone. Volumes which need no
+// changes are omitted, and volumes which do not record their overrides are
+// planned as skipped.
+func (c *TopLevelConfig) PlanTenantApply(tenant string, fields []string) ([]*VolumeChange, error) {
+	tc, _, err := c.ResolveTenant(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range fields {
+		if _, ok := optionField(field); !ok {
+			return nil, fmt.Errorf("Unknown option field %q", field)
+		}
+
+		switch field {
+		case "pool", "size", "filesystem":
+			return nil, fmt.Errorf("Option field %q cannot be changed on existing volumes", field)
+		}
+	}
+
+	entries, err := c.store.List(c.prefixed(rootVolume, tenant))
+	if err != nil {
+		return nil, err
+	}
+
+	plan := []*VolumeChange{}
+
+	for _, entry := range entries {
+		vc := &VolumeConfig{}
+		if err := unmarshalRecord(rootVolume, []byte(entry.Value), vc); err != nil {
+			return nil, err
+		}
+
+		overrides := map[string]bool{}
+		for _, key := range vc.Overrides {
+			overrides[key] = true
+		}
+
+		// the volume's profile is part of the policy it inherits.
+		target := tc.DefaultVolumeOptions
+		if err := tc.applyProfile(tenant, vc.Profile, &target); err != nil {
+			return nil, fmt.Errorf("Volume %q: %v", vc.VolumeName, err)
+		}
+
+		opts := *vc.Options
+		changes := []OptionChange{}
+
+		for _, field := range fields {
+			index, _ := optionField(field)
+			changes = append(changes, copyOption(
+				reflect.TypeOf(opts).Field(index),
+				reflect.ValueOf(target).Field(index),
+				reflect.ValueOf(&opts).Elem().Field(index),
+				overrides,
+			)...)
+		}
+
+		if len(changes) == 0 {
+			continue
+		}
+
+		// which options such volumes set themselves is unknown, so any of
+		// the changes could undo one.
+		if vc.Overrides == nil {
+			plan = append(plan, &VolumeChange{
+				Volume:  vc.VolumeName,
+				Changes: changes,
+				Skipped: "the volume predates the recording of overridden options; change it with `volcli volume set`",
+			})
+			continue
+		}
+
+		vc.Options = &opts
+
+		if err := vc.Validate(); err != nil {
+			return nil, fmt.Errorf("Volume %q would be invalid: %v", vc.VolumeName, err)
+		}
+
+		for _, change := range changes {
+			if err := tc.checkPolicyValue(tenant, change.Key, vc.Options); err != nil {
+				return nil, fmt.Errorf("Volume %q: %v", vc.VolumeName, err)
+			}
+
+			if change.Key == "map-mode" {
+				if err := c.checkRemap(tenant, vc.VolumeName); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if tc.Quota != nil {
+			if err := tc.Quota.checkVolume(tenant, vc.Options); err != nil {
+				return nil, fmt.Errorf("Volume %q: %v", vc.VolumeName, err)
+			}
+		}
+
+		plan = append(plan, &VolumeChange{
+			Volume:   vc.VolumeName,
+			Changes:  changes,
+			config:   vc,
+			revision: entry.Revision,
+		})
+	}
+
+	return plan, nil
+}
+
+// ApplyVolumeChanges writes the changes computed by PlanTenantApply. A volume
+// which fails does not stop the others; the failures are returned keyed by
+// volume, and the volumes missing from them were updated, or skipped as
+// planned. ErrConflict means the volume changed since the plan was made.
+func (c *TopLevelConfig) ApplyVolumeChanges(plan []*VolumeChange) map[string]error {
+	failed := map[string]error{}
+
+	for _, change := range plan {
+		if change.Skipped != "" {
+			continue
+		}
+
+		if err := c.applyVolumeChange(change); err != nil {
+			failed[change.Volume] = err
+		}
+	}
+
+	return failed
+}
+
+func (c *TopLevelConfig) applyVolumeChange(change *VolumeChange) error {
+	// the volume may have been mounted since the plan was made.
+	for _, option := range change.Changes {
+		if option.Key == "map-mode" {
+			if err := c.checkRemap(change.config.TenantName, change.Volume); err != nil {
+				return err
+			}
+		}
+	}
+
+	remarshal, err := marshalRecord(rootVolume, change.config)
+	if err != nil {
+		return err
+	}
+
+	key := c.volume(change.config.TenantName, change.config.VolumeName)
+
+	if _, err := c.store.Set(key, string(remarshal), SetOptions{PrevExist: PrevExist, PrevRevision: change.revision}); err != nil {
+		return conflict(err)
+	}
+
+	return nil
+}
+
+// optionField returns the index of the VolumeOptions field with the JSON
+// name supplied.
+func optionField(name string) (int, bool) {
+	typ := reflect.TypeOf(VolumeOptions{})
+
+	for x := 0; x < typ.NumField(); x++ {
+		if strings.Split(typ.Field(x).Tag.Get("json"), ",")[0] == name {
+			return x, true
+		}
+	}
+
+	return 0, false
+}
+
+// copyOption copies from into to, recursing into structs, and returns the
+// options which changed. Options whose merge key is in overrides are skipped.
+func copyOption(field reflect.StructField, from, to reflect.Value, overrides map[string]bool) []OptionChange {
+	if field.Type.Kind() == reflect.Struct {
+		changes := []OptionChange{}
+		for x := 0; x < field.Type.NumField(); x++ {
+			changes = append(changes, copyOption(field.Type.Field(x), from.Field(x), to.Field(x), overrides)...)
+		}
+
+		return changes
+	}
+
+	key := field.Tag.Get("merge")
+	if overrides[key] || reflect.DeepEqual(from.Interface(), to.Interface()) {
+		return nil
+	}
+
+	change := OptionChange{Key: key, Old: fmt.Sprint(to.Interface()), New: fmt.Sprint(from.Interface())}
+	to.Set(from)
+
+	return []OptionChange{change}
+}
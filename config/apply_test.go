@@ -0,0 +1,103 @@
+package config
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *configSuite) TestTenantApply(c *C) {
+	c.Assert(s.tlc.PublishTenant("foo", testTenantConfigs["basic"]), IsNil)
+
+	_, err := s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "a"})
+	c.Assert(err, IsNil)
+	defer s.tlc.RemoveVolume("foo", "a", 0)
+
+	vcfg, err := s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "b", Opts: map[string]string{"rate-limit.write.iops": "10"}})
+	c.Assert(err, IsNil)
+	defer s.tlc.RemoveVolume("foo", "b", 0)
+	c.Assert(vcfg.Overrides, DeepEquals, []string{"rate-limit.write.iops"})
+
+	tc := &TenantConfig{
+		DefaultVolumeOptions: VolumeOptions{
+			Pool:       "rbd",
+			Size:       10,
+			FileSystem: defaultFilesystem,
+			Snapshot:   SnapshotConfig{Frequency: "1h", Keep: 5},
+			RateLimit:  RateLimitConfig{WriteIOPS: 1000, ReadIOPS: 1000},
+		},
+		FileSystems: defaultFilesystems,
+	}
+
+	c.Assert(s.tlc.PublishTenant("foo", tc), IsNil)
+
+	for _, fields := range [][]string{{"pool"}, {"quux"}} {
+		_, err := s.tlc.PlanTenantApply("foo", fields)
+		c.Assert(err, NotNil)
+	}
+
+	plan, err := s.tlc.PlanTenantApply("foo", []string{"rate-limit"})
+	c.Assert(err, IsNil)
+	c.Assert(plan, HasLen, 2)
+	c.Assert(plan[0].Volume, Equals, "a")
+	c.Assert(plan[0].Changes, DeepEquals, []OptionChange{
+		{Key: "rate-limit.write.iops", Old: "0", New: "1000"},
+		{Key: "rate-limit.read.iops", Old: "0", New: "1000"},
+	})
+	c.Assert(plan[1].Volume, Equals, "b")
+	c.Assert(plan[1].Changes, DeepEquals, []OptionChange{
+		{Key: "rate-limit.read.iops", Old: "0", New: "1000"},
+	})
+
+	// a volume which changes after the plan was made is not overwritten, and
+	// does not stop the others.
+	_, err = s.tlc.UpdateVolume(RequestUpdate{Tenant: "foo", Volume: "a", Opts: map[string]string{"ephemeral": "true"}})
+	c.Assert(err, IsNil)
+	c.Assert(s.tlc.ApplyVolumeChanges(plan), DeepEquals, map[string]error{"a": ErrConflict})
+
+	vcfg, err = s.tlc.GetVolume("foo", "b")
+	c.Assert(err, IsNil)
+	c.Assert(vcfg.Options.RateLimit.ReadIOPS, Equals, uint(1000))
+
+	plan, err = s.tlc.PlanTenantApply("foo", []string{"rate-limit", "snapshot"})
+	c.Assert(err, IsNil)
+	c.Assert(plan, HasLen, 2)
+	c.Assert(s.tlc.ApplyVolumeChanges(plan), HasLen, 0)
+
+	vcfg, err = s.tlc.GetVolume("foo", "a")
+	c.Assert(err, IsNil)
+	c.Assert(vcfg.Options.Ephemeral, Equals, true)
+	c.Assert(vcfg.Options.RateLimit, DeepEquals, RateLimitConfig{WriteIOPS: 1000, ReadIOPS: 1000})
+	c.Assert(vcfg.Options.Snapshot, DeepEquals, SnapshotConfig{Frequency: "1h", Keep: 5})
+
+	vcfg, err = s.tlc.GetVolume("foo", "b")
+	c.Assert(err, IsNil)
+	c.Assert(vcfg.Options.RateLimit, DeepEquals, RateLimitConfig{WriteIOPS: 10, ReadIOPS: 1000})
+
+	plan, err = s.tlc.PlanTenantApply("foo", []string{"rate-limit", "snapshot"})
+	c.Assert(err, IsNil)
+	c.Assert(plan, HasLen, 0)
+}
+
+func (s *configSuite) TestTenantApplyUnknownOverrides(c *C) {
+	c.Assert(s.tlc.PublishTenant("foo", testTenantConfigs["basic"]), IsNil)
+
+	// records written before overrides were recorded have none.
+	old := `{"tenant":"foo","name":"old","options":{"pool":"rbd","size":10,"filesystem":"ext4","rate-limit":{"write-iops":50}}}`
+	_, err := s.tlc.store.Set(s.tlc.volume("foo", "old"), old, SetOptions{})
+	c.Assert(err, IsNil)
+
+	// updating such a volume does not make its overrides known.
+	vcfg, err := s.tlc.UpdateVolume(RequestUpdate{Tenant: "foo", Volume: "old", Opts: map[string]string{"ephemeral": "true"}})
+	c.Assert(err, IsNil)
+	c.Assert(vcfg.Overrides, IsNil)
+
+	plan, err := s.tlc.PlanTenantApply("foo", []string{"rate-limit"})
+	c.Assert(err, IsNil)
+	c.Assert(plan, HasLen, 1)
+	c.Assert(plan[0].Volume, Equals, "old")
+	c.Assert(plan[0].Skipped, Not(Equals), "")
+	c.Assert(s.tlc.ApplyVolumeChanges(plan), HasLen, 0)
+
+	vcfg, err = s.tlc.GetVolume("foo", "old")
+	c.Assert(err, IsNil)
+	c.Assert(vcfg.Options.RateLimit.WriteIOPS, Equals, uint(50))
+}
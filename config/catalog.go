@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// OptionInfo describes an option users may pass with `docker volume create
+// --opt`.
+type OptionInfo struct {
+	Key         string `json:"key"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	Default     string `json:"default"`
+}
+
+// OptionCatalog returns every supported option, with the defaults taken from
+// the supplied options; nil uses the built-in defaults.
+func OptionCatalog(defaults *VolumeOptions) []OptionInfo {
+	if defaults == nil {
+		defaults = &VolumeOptions{FileSystem: defaultFilesystem}
+	}
+
+	catalog := []OptionInfo{}
+
+	for _, field := range mergeFields(reflect.TypeOf(*defaults)) {
+		key := field.Tag.Get("merge")
+		value, _ := lookupKey(defaults, key)
+
+		catalog = append(catalog, OptionInfo{
+			Key:         key,
+			Type:        optionType(field),
+			Description: field.Tag.Get("desc"),
+			Default:     fmt.Sprint(value.Interface()),
+		})
+	}
+
+	catalog = append(catalog, OptionInfo{
+		Key:         profileKey,
+		Type:        "string",
+		Description: "Profile of the tenant to start from",
+	})
+
+	sort.Sort(optionsByKey(catalog))
+
+	return catalog
+}
+
+type optionsByKey []OptionInfo
+
+func (o optionsByKey) Len() int           { return len(o) }
+func (o optionsByKey) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o optionsByKey) Less(i, j int) bool { return o[i].Key < o[j].Key }
+
+func optionType(field reflect.StructField) string {
+	if unit := field.Tag.Get("unit"); unit != "" {
+		return unit
+	}
+
+	switch field.Type.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	}
+
+	return field.Type.Kind().String()
+}
+
+// unknownOptionError names an unknown option and suggests the closest known
+// one.
+func unknownOptionError(key string) error {
+	best := ""
+	bestDistance := 0
+
+	for _, info := range OptionCatalog(nil) {
+		distance := editDistance(key, info.Key)
+		if best == "" || distance < bestDistance {
+			best, bestDistance = info.Key, distance
+		}
+	}
+
+	// suggestions which take rewriting half the key are not helpful.
+	if bestDistance*2 <= len(key) {
+		return fmt.Errorf("Unknown option %q; did you mean %q?", key, best)
+	}
+
+	return fmt.Errorf("Unknown option %q; run `volcli options` for the supported options", key)
+}
+
+// editDistance computes the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	ret := values[0]
+	for _, value := range values[1:] {
+		if value < ret {
+			ret = value
+		}
+	}
+
+	return ret
+}
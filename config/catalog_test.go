@@ -0,0 +1,68 @@
+package config
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *configSuite) TestOptionCatalog(c *C) {
+	catalog := OptionCatalog(nil)
+
+	keys := []string{}
+	byKey := map[string]OptionInfo{}
+	for _, info := range catalog {
+		keys = append(keys, info.Key)
+		byKey[info.Key] = info
+		c.Assert(info.Description, Not(Equals), "", Commentf(info.Key))
+	}
+
+	c.Assert(keys, DeepEquals, []string{
+		"ephemeral",
+		"filesystem",
+		"map-mode",
+		"pool",
+		"profile",
+		"rate-limit.read.bps",
+		"rate-limit.read.iops",
+		"rate-limit.write.bps",
+		"rate-limit.write.iops",
+		"shared-readonly",
+		"size",
+		"snapshots",
+		"snapshots.frequency",
+		"snapshots.keep",
+		"trim",
+	})
+
+	c.Assert(byKey["size"].Type, Equals, "size")
+	c.Assert(byKey["snapshots.frequency"].Type, Equals, "duration")
+	c.Assert(byKey["snapshots"].Type, Equals, "bool")
+	c.Assert(byKey["rate-limit.write.iops"].Type, Equals, "integer")
+	c.Assert(byKey["pool"].Type, Equals, "string")
+	c.Assert(byKey["filesystem"].Default, Equals, "ext4")
+
+	for _, info := range OptionCatalog(&testTenantConfigs["basic2"].DefaultVolumeOptions) {
+		if info.Key == "size" {
+			c.Assert(info.Default, Equals, "20")
+		}
+	}
+}
+
+func (s *configSuite) TestMergeErrors(c *C) {
+	v := VolumeOptions{}
+
+	c.Assert(mergeOpts(&v, map[string]string{"snapshot.keep": "10"}), ErrorMatches, `Unknown option "snapshot.keep"; did you mean "snapshots.keep"\?`)
+	c.Assert(mergeOpts(&v, map[string]string{"rate-limit.write-iops": "10"}), ErrorMatches, `Unknown option "rate-limit.write-iops"; did you mean "rate-limit.write.iops"\?`)
+	c.Assert(mergeOpts(&v, map[string]string{"sise": "10"}), ErrorMatches, `Unknown option "sise"; did you mean "size"\?`)
+	c.Assert(mergeOpts(&v, map[string]string{"quux": "10"}), ErrorMatches, `Unknown option "quux"; run .volcli options. for the supported options`)
+
+	c.Assert(mergeOpts(&v, map[string]string{"snapshots.keep": "many"}), ErrorMatches, `Invalid value "many" for option "snapshots.keep": .*`)
+	c.Assert(mergeOpts(&v, map[string]string{"snapshots": "maybe"}), ErrorMatches, `Invalid value "maybe" for option "snapshots": .*`)
+	c.Assert(mergeOpts(&v, map[string]string{"size": "-1"}), ErrorMatches, `Invalid value "-1" for option "size": .*`)
+}
+
+func (s *configSuite) TestEditDistance(c *C) {
+	c.Assert(editDistance("", ""), Equals, 0)
+	c.Assert(editDistance("size", ""), Equals, 4)
+	c.Assert(editDistance("kitten", "sitting"), Equals, 3)
+	c.Assert(editDistance("snapshot.keep", "snapshots.keep"), Equals, 1)
+}
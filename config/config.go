@@ -3,27 +3,34 @@ package config
 import (
 	"errors"
 	"path"
-
-	"github.com/coreos/etcd/client"
-	"golang.org/x/net/context"
+	"strings"
+	"time"
 )
 
 const (
 	rootVolume = "volumes"
 	rootMount  = "mounts"
+	rootHolder = "holders"
 	rootTenant = "tenants"
+	rootTrim   = "trims"
+	rootGlobal = "global"
 )
 
-var defaultPaths = []string{rootVolume, rootMount, rootTenant}
-
 // ErrExist indicates when a key in etcd exits already. Used for create logic.
 var ErrExist = errors.New("Already exists")
 
+// ErrConflict indicates that a record was modified since the revision a
+// change to it expected.
+var ErrConflict = errors.New("Revision conflict: the record was modified concurrently")
+
 // Request provides a request structure for communicating with the
 // volmaster.
 type Request struct {
 	Volume string `json:"volume"`
 	Tenant string `json:"tenant"`
+
+	// Revision, if non-zero, is the revision the volume is expected to be at.
+	Revision uint64 `json:"revision,omitempty"`
 }
 
 // RequestCreate provides a request structure for creating new volumes.
@@ -33,35 +40,69 @@ type RequestCreate struct {
 	Opts   map[string]string `json:"opts"`
 }
 
+// RequestUpdate provides a request structure for changing the options of an
+// existing volume.
+type RequestUpdate struct {
+	Tenant string            `json:"tenant"`
+	Volume string            `json:"volume"`
+	Opts   map[string]string `json:"opts"`
+
+	// Revision, if non-zero, is the revision the volume is expected to be at.
+	Revision uint64 `json:"revision,omitempty"`
+}
+
+// RequestFreeze provides a request structure for freezing the filesystem of a
+// volume on the host which has it mounted. The host thaws the filesystem by
+// itself once the timeout passes.
+type RequestFreeze struct {
+	Tenant  string        `json:"tenant"`
+	Volume  string        `json:"volume"`
+	Timeout time.Duration `json:"timeout"`
+}
+
+// RequestAdopt provides a request structure for bringing a pre-existing RBD
+// image under management.
+type RequestAdopt struct {
+	Tenant string `json:"tenant"`
+	Volume string `json:"volume"`
+	Pool   string `json:"pool"`
+	Image  string `json:"image"`
+	Rename bool   `json:"rename"`
+}
+
+// RequestMigrate provides a request structure for moving a volume to another
+// pool.
+type RequestMigrate struct {
+	Tenant    string `json:"tenant"`
+	Volume    string `json:"volume"`
+	Pool      string `json:"pool"`
+	Snapshots bool   `json:"snapshots"`
+}
+
 // TopLevelConfig is the top-level struct for communicating with the intent store.
 type TopLevelConfig struct {
-	etcdClient client.KeysAPI
-	prefix     string
+	store  Store
+	prefix string
 }
 
 // NewTopLevelConfig creates a TopLevelConfig struct which can drive communication
 // with the configuration store.
 func NewTopLevelConfig(prefix string, etcdHosts []string) (*TopLevelConfig, error) {
-	etcdCfg := client.Config{
-		Endpoints: etcdHosts,
-	}
-
-	etcdClient, err := client.New(etcdCfg)
+	store, err := NewEtcdStore(etcdHosts)
 	if err != nil {
 		return nil, err
 	}
 
-	config := &TopLevelConfig{
-		prefix:     prefix,
-		etcdClient: client.NewKeysAPI(etcdClient),
-	}
+	return NewTopLevelConfigWithStore(prefix, store), nil
+}
 
-	config.etcdClient.Set(context.Background(), config.prefix, "", &client.SetOptions{Dir: true})
-	for _, path := range defaultPaths {
-		config.etcdClient.Set(context.Background(), config.prefixed(path), "", &client.SetOptions{Dir: true})
+// NewTopLevelConfigWithStore creates a TopLevelConfig which keeps its
+// configuration in the supplied store.
+func NewTopLevelConfigWithStore(prefix string, store Store) *TopLevelConfig {
+	return &TopLevelConfig{
+		prefix: prefix,
+		store:  store,
 	}
-
-	return config, nil
 }
 
 func (c *TopLevelConfig) prefixed(strs ...string) string {
@@ -72,3 +113,18 @@ func (c *TopLevelConfig) prefixed(strs ...string) string {
 
 	return str
 }
+
+// relative returns the key of the entry relative to the root directory.
+func (c *TopLevelConfig) relative(root string, entry *Entry) string {
+	return strings.TrimPrefix(entry.Key, c.prefixed(root)+"/")
+}
+
+// conflict translates the failed comparison of a conditional write into
+// ErrConflict.
+func conflict(err error) error {
+	if err == ErrCompareFailed {
+		return ErrConflict
+	}
+
+	return err
+}
@@ -1,10 +1,8 @@
 package config
 
 import (
-	"os/exec"
 	"path"
 	. "testing"
-	"time"
 
 	. "gopkg.in/check.v1"
 )
@@ -18,19 +16,7 @@ var _ = Suite(&configSuite{})
 func TestConfig(t *T) { TestingT(t) }
 
 func (s *configSuite) SetUpTest(c *C) {
-	exec.Command("/bin/sh", "-c", "etcdctl rm --recursive /volplugin").Run()
-}
-
-func (s *configSuite) SetUpSuite(c *C) {
-	c.Assert(exec.Command("/bin/sh", "-c", "sudo systemctl start etcd").Run(), IsNil)
-	time.Sleep(200 * time.Millisecond)
-
-	tlc, err := NewTopLevelConfig("/volplugin", []string{"http://127.0.0.1:2379"})
-	if err != nil {
-		c.Fatal(err)
-	}
-
-	s.tlc = tlc
+	s.tlc = NewTopLevelConfigWithStore("/volplugin", NewMemoryStore())
 }
 
 func (s *configSuite) TestPrefixed(c *C) {
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"sort"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// etcdStore is a Store backed by the etcd v2 keys API.
+type etcdStore struct {
+	keysAPI client.KeysAPI
+}
+
+// NewEtcdStore returns a Store which talks to the etcd v2 keys API on the
+// supplied hosts.
+func NewEtcdStore(etcdHosts []string) (Store, error) {
+	etcdClient, err := client.New(client.Config{Endpoints: etcdHosts})
+	if err != nil {
+		return nil, err
+	}
+
+	return &etcdStore{keysAPI: client.NewKeysAPI(etcdClient)}, nil
+}
+
+func translateEtcdError(err error) error {
+	if cerr, ok := err.(client.Error); ok {
+		switch cerr.Code {
+		case client.ErrorCodeKeyNotFound:
+			return ErrNotExist
+		case client.ErrorCodeNodeExist:
+			return ErrExist
+		case client.ErrorCodeTestFailed:
+			return ErrCompareFailed
+		}
+	}
+
+	return err
+}
+
+func entryFromNode(node *client.Node) *Entry {
+	return &Entry{Key: node.Key, Value: node.Value, Revision: node.ModifiedIndex, TTL: time.Duration(node.TTL) * time.Second}
+}
+
+func (s *etcdStore) Get(key string) (*Entry, error) {
+	resp, err := s.keysAPI.Get(context.Background(), key, nil)
+	if err != nil {
+		return nil, translateEtcdError(err)
+	}
+
+	return entryFromNode(resp.Node), nil
+}
+
+func (s *etcdStore) Set(key, value string, opts SetOptions) (*Entry, error) {
+	setOpts := &client.SetOptions{PrevValue: opts.PrevValue, PrevIndex: opts.PrevRevision, TTL: opts.TTL}
+
+	switch opts.PrevExist {
+	case PrevExist:
+		setOpts.PrevExist = client.PrevExist
+	case PrevNoExist:
+		setOpts.PrevExist = client.PrevNoExist
+	default:
+		setOpts.PrevExist = client.PrevIgnore
+	}
+
+	resp, err := s.keysAPI.Set(context.Background(), key, value, setOpts)
+	if err != nil {
+		return nil, translateEtcdError(err)
+	}
+
+	return entryFromNode(resp.Node), nil
+}
+
+func (s *etcdStore) Delete(key string, opts DeleteOptions) error {
+	_, err := s.keysAPI.Delete(context.Background(), key, &client.DeleteOptions{PrevValue: opts.PrevValue, PrevIndex: opts.PrevRevision})
+	return translateEtcdError(err)
+}
+
+func (s *etcdStore) List(dir string) ([]*Entry, error) {
+	resp, err := s.keysAPI.Get(context.Background(), dir, &client.GetOptions{Recursive: true, Sort: true})
+	if err != nil {
+		if err := translateEtcdError(err); err == ErrNotExist {
+			return []*Entry{}, nil
+		}
+
+		return nil, translateEtcdError(err)
+	}
+
+	entries := []*Entry{}
+	walkNodes(resp.Node.Nodes, &entries)
+	sort.Sort(entriesByKey(entries))
+
+	return entries, nil
+}
+
+func walkNodes(nodes []*client.Node, entries *[]*Entry) {
+	for _, node := range nodes {
+		if node.Dir {
+			walkNodes(node.Nodes, entries)
+			continue
+		}
+
+		*entries = append(*entries, entryFromNode(node))
+	}
+}
+
+func (s *etcdStore) Watch(dir string, stop <-chan struct{}) (<-chan *WatchEvent, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	watcher := s.keysAPI.Watcher(dir, &client.WatcherOptions{Recursive: true})
+	events := make(chan *WatchEvent)
+
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	go func() {
+		defer close(events)
+
+		for {
+			resp, err := watcher.Next(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Errorf("Watching %q failed: %v", dir, err)
+				}
+				return
+			}
+
+			if resp.Node.Dir {
+				continue
+			}
+
+			event := &WatchEvent{Entry: entryFromNode(resp.Node)}
+
+			switch resp.Action {
+			case "delete", "compareAndDelete", "expire":
+				event.Deleted = true
+				event.Entry.Value = ""
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+type entriesByKey []*Entry
+
+func (e entriesByKey) Len() int           { return len(e) }
+func (e entriesByKey) Less(i, j int) bool { return e[i].Key < e[j].Key }
+func (e entriesByKey) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
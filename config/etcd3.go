@@ -0,0 +1,530 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// etcd3Store is a Store backed by the etcd v3 KV API. It talks to the JSON
+// gateway etcd serves on its client port, so it needs no gRPC client.
+//
+// Directories do not exist in v3; a directory is the range of keys sharing
+// its prefix. PrevExist, PrevValue and PrevRevision conditions are implemented as
+// transactions, and TTLs as leases.
+type etcd3Store struct {
+	hosts  []string
+	client *http.Client
+}
+
+// NewEtcd3Store returns a Store which talks to the etcd v3 KV API on the
+// supplied hosts.
+func NewEtcd3Store(etcdHosts []string) (Store, error) {
+	if len(etcdHosts) == 0 {
+		return nil, fmt.Errorf("No etcd hosts supplied")
+	}
+
+	return &etcd3Store{hosts: etcdHosts, client: &http.Client{}}, nil
+}
+
+// int64String decodes the int64 fields of the gateway, which are quoted.
+type int64String int64
+
+func (i *int64String) UnmarshalJSON(content []byte) error {
+	val, err := strconv.ParseInt(strings.Trim(string(content), `"`), 10, 64)
+	*i = int64String(val)
+	return err
+}
+
+type etcd3KeyValue struct {
+	Key            []byte      `json:"key"`
+	Value          []byte      `json:"value"`
+	CreateRevision int64String `json:"create_revision"`
+	ModRevision    int64String `json:"mod_revision"`
+	Lease          int64String `json:"lease"`
+}
+
+type etcd3Header struct {
+	Revision int64String `json:"revision"`
+}
+
+type etcd3RangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end,omitempty"`
+}
+
+type etcd3RangeResponse struct {
+	Header etcd3Header     `json:"header"`
+	Kvs    []etcd3KeyValue `json:"kvs"`
+}
+
+type etcd3PutRequest struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	Lease int64  `json:"lease,string,omitempty"`
+}
+
+type etcd3DeleteRequest struct {
+	Key []byte `json:"key"`
+}
+
+// etcd3Compare is a condition of a transaction. Value, CreateRevision and
+// ModRevision are alternatives for the gateway, so only the one matching
+// Target may be set; use the compare functions below.
+type etcd3Compare struct {
+	Result         string `json:"result"`
+	Target         string `json:"target"`
+	Key            []byte `json:"key"`
+	Value          []byte `json:"value,omitempty"`
+	CreateRevision *int64 `json:"create_revision,string,omitempty"`
+	ModRevision    *int64 `json:"mod_revision,string,omitempty"`
+}
+
+// compareExists requires key to exist if exists is true, and to not exist
+// otherwise.
+func compareExists(key string, exists bool) etcd3Compare {
+	result := "EQUAL"
+	if exists {
+		result = "GREATER"
+	}
+
+	var none int64
+	return etcd3Compare{Result: result, Target: "CREATE", Key: []byte(key), CreateRevision: &none}
+}
+
+// compareValue requires the value of key to be value.
+func compareValue(key, value string) etcd3Compare {
+	return etcd3Compare{Result: "EQUAL", Target: "VALUE", Key: []byte(key), Value: []byte(value)}
+}
+
+// compareRevision requires key to have last been modified at revision.
+func compareRevision(key string, revision uint64) etcd3Compare {
+	rev := int64(revision)
+	return etcd3Compare{Result: "EQUAL", Target: "MOD", Key: []byte(key), ModRevision: &rev}
+}
+
+type etcd3RequestOp struct {
+	RequestPut         *etcd3PutRequest    `json:"request_put,omitempty"`
+	RequestDeleteRange *etcd3DeleteRequest `json:"request_delete_range,omitempty"`
+}
+
+type etcd3TxnRequest struct {
+	Compare []etcd3Compare   `json:"compare"`
+	Success []etcd3RequestOp `json:"success"`
+}
+
+type etcd3TxnResponse struct {
+	Header    etcd3Header `json:"header"`
+	Succeeded bool        `json:"succeeded"`
+}
+
+type etcd3LeaseGrantRequest struct {
+	TTL int64 `json:"TTL,string"`
+}
+
+type etcd3LeaseGrantResponse struct {
+	ID int64String `json:"ID"`
+}
+
+type etcd3LeaseRequest struct {
+	ID int64 `json:"ID,string"`
+}
+
+type etcd3LeaseTimeToLiveResponse struct {
+	TTL int64String `json:"TTL"`
+}
+
+type etcd3WatchCreateRequest struct {
+	Key           []byte `json:"key"`
+	RangeEnd      []byte `json:"range_end,omitempty"`
+	StartRevision int64  `json:"start_revision,string,omitempty"`
+}
+
+type etcd3WatchResponse struct {
+	Result struct {
+		Header etcd3Header `json:"header"`
+		Events []struct {
+			Type string        `json:"type"`
+			Kv   etcd3KeyValue `json:"kv"`
+		} `json:"events"`
+	} `json:"result"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// prefixEnd returns the end of the range of keys starting with prefix.
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	// the prefix is all 0xff; range to the end of the keyspace.
+	return []byte{0}
+}
+
+func (s *etcd3Store) post(path string, req, resp interface{}) error {
+	content, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+
+	for _, host := range s.hosts {
+		httpResp, err := s.client.Post(strings.TrimSuffix(host, "/")+path, "application/json", bytes.NewBuffer(content))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		body, err := ioutil.ReadAll(httpResp.Body)
+		httpResp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if httpResp.StatusCode != 200 {
+			return fmt.Errorf("etcd returned status %d for %s: %q", httpResp.StatusCode, path, strings.TrimSpace(string(body)))
+		}
+
+		return json.Unmarshal(body, resp)
+	}
+
+	return lastErr
+}
+
+func (s *etcd3Store) rangeKeys(req etcd3RangeRequest) (*etcd3RangeResponse, error) {
+	resp := &etcd3RangeResponse{}
+	if err := s.post("/v3/kv/range", req, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (s *etcd3Store) Get(key string) (*Entry, error) {
+	resp, err := s.rangeKeys(etcd3RangeRequest{Key: []byte(key)})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Kvs) == 0 {
+		return nil, ErrNotExist
+	}
+
+	return s.entry(resp.Kvs[0])
+}
+
+// entry converts a key of the gateway to an Entry, looking up the time left
+// on its lease if it has one.
+func (s *etcd3Store) entry(kv etcd3KeyValue) (*Entry, error) {
+	entry := &Entry{Key: string(kv.Key), Value: string(kv.Value), Revision: uint64(kv.ModRevision)}
+
+	if kv.Lease != 0 {
+		resp := &etcd3LeaseTimeToLiveResponse{}
+		if err := s.post("/v3/lease/timetolive", etcd3LeaseRequest{ID: int64(kv.Lease)}, resp); err != nil {
+			return nil, err
+		}
+
+		// a lease which expired since the key was read reports -1; the key is
+		// about to go away, so it is kept for the shortest TTL there is.
+		entry.TTL = time.Second
+		if resp.TTL > 0 {
+			entry.TTL = time.Duration(resp.TTL) * time.Second
+		}
+	}
+
+	return entry, nil
+}
+
+// revokeLease revokes a lease which ended up unused, so that it does not
+// linger until its TTL passes.
+func (s *etcd3Store) revokeLease(id int64) {
+	if err := s.post("/v3/lease/revoke", etcd3LeaseRequest{ID: id}, &struct{}{}); err != nil {
+		log.Warnf("Could not revoke unused etcd lease %d: %v", id, err)
+	}
+}
+
+func (s *etcd3Store) Set(key, value string, opts SetOptions) (*Entry, error) {
+	put := &etcd3PutRequest{Key: []byte(key), Value: []byte(value)}
+
+	if opts.TTL > 0 {
+		lease := &etcd3LeaseGrantResponse{}
+		if err := s.post("/v3/lease/grant", etcd3LeaseGrantRequest{TTL: int64((opts.TTL + time.Second - 1) / time.Second)}, lease); err != nil {
+			return nil, err
+		}
+
+		put.Lease = int64(lease.ID)
+	}
+
+	txn := etcd3TxnRequest{Success: []etcd3RequestOp{{RequestPut: put}}}
+
+	switch opts.PrevExist {
+	case PrevExist:
+		txn.Compare = append(txn.Compare, compareExists(key, true))
+	case PrevNoExist:
+		txn.Compare = append(txn.Compare, compareExists(key, false))
+	}
+
+	if opts.PrevValue != "" {
+		txn.Compare = append(txn.Compare, compareValue(key, opts.PrevValue))
+	}
+
+	if opts.PrevRevision != 0 {
+		txn.Compare = append(txn.Compare, compareRevision(key, opts.PrevRevision))
+	}
+
+	resp := &etcd3TxnResponse{}
+	if err := s.post("/v3/kv/txn", txn, resp); err != nil {
+		if put.Lease != 0 {
+			s.revokeLease(put.Lease)
+		}
+		return nil, err
+	}
+
+	if !resp.Succeeded {
+		if put.Lease != 0 {
+			s.revokeLease(put.Lease)
+		}
+		return nil, s.compareError(key, opts.PrevExist)
+	}
+
+	return &Entry{Key: key, Value: value, Revision: uint64(resp.Header.Revision)}, nil
+}
+
+// compareError determines why a conditional transaction on key failed.
+func (s *etcd3Store) compareError(key string, prevExist PrevExistType) error {
+	_, err := s.Get(key)
+	switch err {
+	case nil:
+		if prevExist == PrevNoExist {
+			return ErrExist
+		}
+
+		return ErrCompareFailed
+	case ErrNotExist:
+		return ErrNotExist
+	default:
+		return err
+	}
+}
+
+func (s *etcd3Store) Delete(key string, opts DeleteOptions) error {
+	txn := etcd3TxnRequest{
+		Compare: []etcd3Compare{compareExists(key, true)},
+		Success: []etcd3RequestOp{{RequestDeleteRange: &etcd3DeleteRequest{Key: []byte(key)}}},
+	}
+
+	if opts.PrevValue != "" {
+		txn.Compare = append(txn.Compare, compareValue(key, opts.PrevValue))
+	}
+
+	if opts.PrevRevision != 0 {
+		txn.Compare = append(txn.Compare, compareRevision(key, opts.PrevRevision))
+	}
+
+	resp := &etcd3TxnResponse{}
+	if err := s.post("/v3/kv/txn", txn, resp); err != nil {
+		return err
+	}
+
+	if !resp.Succeeded {
+		return s.compareError(key, PrevExist)
+	}
+
+	return nil
+}
+
+func (s *etcd3Store) List(dir string) ([]*Entry, error) {
+	prefix := dirPrefix(dir)
+
+	resp, err := s.rangeKeys(etcd3RangeRequest{Key: []byte(prefix), RangeEnd: prefixEnd(prefix)})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*Entry{}
+	for _, kv := range resp.Kvs {
+		entry, err := s.entry(kv)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	// the range is returned in key order already.
+	return entries, nil
+}
+
+// Watch watches the keys under dir. Like post, it uses the first host which
+// answers. If the stream fails, the watch moves on to the next host, resuming
+// after the last revision it saw, and only fails once every host has failed
+// in turn.
+func (s *etcd3Store) Watch(dir string, stop <-chan struct{}) (<-chan *WatchEvent, error) {
+	prefix := dirPrefix(dir)
+
+	body, host, err := s.openWatch(prefix, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var mutex sync.Mutex
+	stopped := false
+
+	go func() {
+		<-stop
+		mutex.Lock()
+		stopped = true
+		body.Close()
+		mutex.Unlock()
+	}()
+
+	events := make(chan *WatchEvent)
+
+	go func() {
+		defer close(events)
+
+		var revision int64
+		failures := 0
+
+		for {
+			seen := revision
+
+			err := readWatch(body, &revision, events, stop)
+			if err == nil {
+				return
+			}
+
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			if revision != seen {
+				failures = 0
+			}
+
+			failures++
+			if failures >= len(s.hosts) {
+				log.Errorf("Watching %q failed: %v", dir, err)
+				return
+			}
+
+			log.Warnf("Watching %q on %s failed, trying the next host: %v", dir, s.hosts[host], err)
+
+			mutex.Lock()
+			if stopped {
+				mutex.Unlock()
+				return
+			}
+
+			var start int64
+			if revision > 0 {
+				start = revision + 1
+			}
+
+			next, nextHost, err := s.openWatch(prefix, start, host+1)
+			if err != nil {
+				mutex.Unlock()
+				log.Errorf("Watching %q failed: %v", dir, err)
+				return
+			}
+
+			body, host = next, nextHost
+			mutex.Unlock()
+		}
+	}()
+
+	return events, nil
+}
+
+// openWatch opens a watch of the keys under prefix from revision start, or
+// from now if start is zero. The hosts are tried in turn, beginning with the
+// host at index first. The stream and the index of its host are returned.
+func (s *etcd3Store) openWatch(prefix string, start int64, first int) (io.ReadCloser, int, error) {
+	content, err := json.Marshal(map[string]interface{}{
+		"create_request": etcd3WatchCreateRequest{Key: []byte(prefix), RangeEnd: prefixEnd(prefix), StartRevision: start},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var lastErr error
+
+	for i := 0; i < len(s.hosts); i++ {
+		host := (first + i) % len(s.hosts)
+
+		resp, err := s.client.Post(strings.TrimSuffix(s.hosts[host], "/")+"/v3/watch", "application/json", bytes.NewBuffer(content))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode != 200 {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("etcd returned status %d for watch", resp.StatusCode)
+			continue
+		}
+
+		return resp.Body, host, nil
+	}
+
+	return nil, 0, lastErr
+}
+
+// readWatch sends the events of the watch stream body until the stream
+// fails, which is returned, or the watch is stopped, which returns nil. The
+// latest revision the stream reported is kept in revision.
+func readWatch(body io.Reader, revision *int64, events chan<- *WatchEvent, stop <-chan struct{}) error {
+	decoder := json.NewDecoder(body)
+
+	for {
+		watchResp := &etcd3WatchResponse{}
+		if err := decoder.Decode(watchResp); err != nil {
+			return err
+		}
+
+		if watchResp.Error != nil {
+			return fmt.Errorf("%s", watchResp.Error.Message)
+		}
+
+		for _, ev := range watchResp.Result.Events {
+			event := &WatchEvent{
+				Deleted: ev.Type == "DELETE",
+				Entry:   &Entry{Key: string(ev.Kv.Key), Value: string(ev.Kv.Value), Revision: uint64(ev.Kv.ModRevision)},
+			}
+
+			select {
+			case events <- event:
+			case <-stop:
+				return nil
+			}
+
+			if int64(ev.Kv.ModRevision) > *revision {
+				*revision = int64(ev.Kv.ModRevision)
+			}
+		}
+
+		// the header is as of the end of the events in the response.
+		if int64(watchResp.Result.Header.Revision) > *revision {
+			*revision = int64(watchResp.Result.Header.Revision)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package config
+
+import "fmt"
+
+func (c *TopLevelConfig) global() string {
+	return c.prefixed(rootGlobal, "defaults")
+}
+
+// PublishGlobal publishes the global defaults, which every tenant inherits.
+// The global defaults are a tenant configuration which may leave out any
+// value, including the pool and size. They are refused if a tenant would
+// become invalid with them.
+func (c *TopLevelConfig) PublishGlobal(cfg *TenantConfig) error {
+	if cfg.Extends != "" {
+		return fmt.Errorf("The global defaults cannot extend a tenant")
+	}
+
+	if err := cfg.DefaultVolumeOptions.validateDurations(); err != nil {
+		return err
+	}
+
+	if err := cfg.validatePolicy(); err != nil {
+		return err
+	}
+
+	for name, opts := range cfg.Profiles {
+		profile := cfg.DefaultVolumeOptions
+		if err := mergeOpts(&profile, opts); err != nil {
+			return fmt.Errorf("Profile %q: %v", name, err)
+		}
+	}
+
+	if err := c.validateDependents(layerGlobal, cfg); err != nil {
+		return err
+	}
+
+	value, err := marshalRecord(rootGlobal, cfg)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.store.Set(c.global(), string(value), SetOptions{PrevExist: PrevIgnore})
+	return err
+}
+
+// GetGlobal retrieves the global defaults. ErrNotExist is returned if none
+// were published.
+func (c *TopLevelConfig) GetGlobal() (*TenantConfig, error) {
+	entry, err := c.store.Get(c.global())
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &TenantConfig{}
+	err = unmarshalRecord(rootGlobal, []byte(entry.Value), cfg)
+
+	return cfg, err
+}
+
+// DeleteGlobal removes the global defaults.
+func (c *TopLevelConfig) DeleteGlobal() error {
+	return c.store.Delete(c.global(), DeleteOptions{})
+}
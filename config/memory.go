@@ -0,0 +1,242 @@
+package config
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStore is a Store which keeps its entries in memory. It is intended
+// for tests and single-process use. Keys with a TTL are expired on the next
+// access to the store.
+type memoryStore struct {
+	mutex    sync.Mutex
+	entries  map[string]*Entry
+	expiry   map[string]time.Time
+	revision uint64
+	watchers map[*memoryWatcher]struct{}
+}
+
+type memoryWatcher struct {
+	dir    string
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	queue  []*WatchEvent
+	closed bool
+}
+
+// NewMemoryStore returns an empty in-memory Store.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		entries:  map[string]*Entry{},
+		expiry:   map[string]time.Time{},
+		watchers: map[*memoryWatcher]struct{}{},
+	}
+}
+
+func dirPrefix(dir string) string {
+	return strings.TrimSuffix(dir, "/") + "/"
+}
+
+func copyEntry(entry *Entry) *Entry {
+	e := *entry
+	return &e
+}
+
+// entry returns a copy of the entry for key, with the time it has left to
+// live. It must be called with the store mutex held.
+func (s *memoryStore) entry(key string) *Entry {
+	entry := copyEntry(s.entries[key])
+	if expiry, ok := s.expiry[key]; ok {
+		entry.TTL = expiry.Sub(time.Now())
+	}
+
+	return entry
+}
+
+// expire removes the entries whose TTL has passed. It must be called with
+// the store mutex held.
+func (s *memoryStore) expire() {
+	now := time.Now()
+
+	for key, expiry := range s.expiry {
+		if now.After(expiry) {
+			s.revision++
+			delete(s.entries, key)
+			delete(s.expiry, key)
+			s.notify(&WatchEvent{Deleted: true, Entry: &Entry{Key: key, Revision: s.revision}})
+		}
+	}
+}
+
+func (s *memoryStore) Get(key string) (*Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expire()
+
+	if _, ok := s.entries[key]; !ok {
+		return nil, ErrNotExist
+	}
+
+	return s.entry(key), nil
+}
+
+func (s *memoryStore) Set(key, value string, opts SetOptions) (*Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expire()
+
+	entry, ok := s.entries[key]
+
+	switch opts.PrevExist {
+	case PrevExist:
+		if !ok {
+			return nil, ErrNotExist
+		}
+	case PrevNoExist:
+		if ok {
+			return nil, ErrExist
+		}
+	}
+
+	if opts.PrevValue != "" || opts.PrevRevision != 0 {
+		if !ok {
+			return nil, ErrNotExist
+		}
+
+		if opts.PrevValue != "" && entry.Value != opts.PrevValue {
+			return nil, ErrCompareFailed
+		}
+
+		if opts.PrevRevision != 0 && entry.Revision != opts.PrevRevision {
+			return nil, ErrCompareFailed
+		}
+	}
+
+	s.revision++
+	entry = &Entry{Key: key, Value: value, Revision: s.revision}
+	s.entries[key] = entry
+
+	delete(s.expiry, key)
+	if opts.TTL > 0 {
+		s.expiry[key] = time.Now().Add(opts.TTL)
+	}
+
+	s.notify(&WatchEvent{Entry: copyEntry(entry)})
+
+	return s.entry(key), nil
+}
+
+func (s *memoryStore) Delete(key string, opts DeleteOptions) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expire()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return ErrNotExist
+	}
+
+	if opts.PrevValue != "" && entry.Value != opts.PrevValue {
+		return ErrCompareFailed
+	}
+
+	if opts.PrevRevision != 0 && entry.Revision != opts.PrevRevision {
+		return ErrCompareFailed
+	}
+
+	s.revision++
+	delete(s.entries, key)
+	delete(s.expiry, key)
+	s.notify(&WatchEvent{Deleted: true, Entry: &Entry{Key: key, Revision: s.revision}})
+
+	return nil
+}
+
+func (s *memoryStore) List(dir string) ([]*Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expire()
+
+	prefix := dirPrefix(dir)
+	entries := []*Entry{}
+
+	for key := range s.entries {
+		if strings.HasPrefix(key, prefix) {
+			entries = append(entries, s.entry(key))
+		}
+	}
+
+	sort.Sort(entriesByKey(entries))
+
+	return entries, nil
+}
+
+func (s *memoryStore) Watch(dir string, stop <-chan struct{}) (<-chan *WatchEvent, error) {
+	w := &memoryWatcher{dir: dirPrefix(dir)}
+	w.cond = sync.NewCond(&w.mutex)
+
+	s.mutex.Lock()
+	s.watchers[w] = struct{}{}
+	s.mutex.Unlock()
+
+	events := make(chan *WatchEvent)
+
+	go func() {
+		<-stop
+
+		s.mutex.Lock()
+		delete(s.watchers, w)
+		s.mutex.Unlock()
+
+		w.mutex.Lock()
+		w.closed = true
+		w.cond.Signal()
+		w.mutex.Unlock()
+	}()
+
+	// events are queued by notify so that a slow consumer never blocks the
+	// store.
+	go func() {
+		defer close(events)
+
+		for {
+			w.mutex.Lock()
+			for len(w.queue) == 0 && !w.closed {
+				w.cond.Wait()
+			}
+
+			if w.closed {
+				w.mutex.Unlock()
+				return
+			}
+
+			event := w.queue[0]
+			w.queue = w.queue[1:]
+			w.mutex.Unlock()
+
+			select {
+			case events <- event:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// notify must be called with the store mutex held.
+func (s *memoryStore) notify(event *WatchEvent) {
+	for w := range s.watchers {
+		if !strings.HasPrefix(event.Entry.Key, w.dir) {
+			continue
+		}
+
+		w.mutex.Lock()
+		w.queue = append(w.queue, event)
+		w.cond.Signal()
+		w.mutex.Unlock()
+	}
+}
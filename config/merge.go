@@ -4,112 +4,162 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 )
 
 // mergeOpts is used to merge docker's driver options (which are flat) with our
 // options data structures (which are not).
 //
-// most of the work is done in setKey() and setValueWithType().
-//
+// each key is the `merge` struct tag of a field in VolumeOptions or one of its
+// sub-structs. The value is converted to the type of the field by
+// setValueWithType(). Fields tagged `unit:"size"` accept sizes with units.
 func mergeOpts(v *VolumeOptions, opts map[string]string) error {
 	for key, value := range opts {
-		ptrVal := reflect.ValueOf(v)
-		if err := setKey(reflect.TypeOf(*v), &ptrVal, key, value); err != nil {
-			return err
+		valfield, field, ok := lookupField(reflect.ValueOf(v).Elem(), key)
+		if !ok {
+			return unknownOptionError(key)
+		}
+
+		// sizes may carry a unit, but are stored in MB.
+		if field.Tag.Get("unit") == "size" {
+			size, err := parseSize(value)
+			if err != nil {
+				return fmt.Errorf("Invalid value %q for option %q: %v", value, key, err)
+			}
+
+			value = strconv.FormatUint(size, 10)
+		}
+
+		if err := setValueWithType(&valfield, value); err != nil {
+			return fmt.Errorf("Invalid value %q for option %q: %v", value, key, err)
 		}
 	}
 
 	return nil
 }
 
-// setKey sets a key to a value within the struct. Requires the reflect data +
-// key/value.
-//
-// See the comments in the function for an explanation of what happens.
-//
-func setKey(typeinfo reflect.Type, valinfo *reflect.Value, key string, value string) error {
-	// walk all fields in the struct
+// mergeKeys returns the merge tags of the leaf fields of the struct type, in
+// field order.
+func mergeKeys(typeinfo reflect.Type) []string {
+	keys := []string{}
+
+	for _, field := range mergeFields(typeinfo) {
+		keys = append(keys, field.Tag.Get("merge"))
+	}
+
+	return keys
+}
+
+// mergeFields returns the leaf fields of the struct type which carry a merge
+// tag, in field order.
+func mergeFields(typeinfo reflect.Type) []reflect.StructField {
+	fields := []reflect.StructField{}
+
 	for x := 0; x < typeinfo.NumField(); x++ {
 		field := typeinfo.Field(x)
 
-		// if we're a struct, recurse. Do not do this for zero element structs,
+		// recurse into sub-structs. Do not do this for zero element structs,
 		// reflect gets a little confused by these.
 		if field.Type.Kind() == reflect.Struct {
 			if field.Type.NumField() > 0 {
-				// valinfo is holding a pointer, so we need to call Elem() to get at
-				// it.
-				valfield := valinfo.Elem().Field(x)
-				err := setKey(field.Type, &valfield, key, value)
-
-				// if the error is non-nil, we don't have a match. Try looping.
-				if err != nil {
-					continue
-				}
-				return nil
+				fields = append(fields, mergeFields(field.Type)...)
 			}
+			continue
 		}
 
-		// merge tag handling, see mergeOpts comments.
-		if field.Tag.Get("merge") == key {
-			var valfield reflect.Value
-
-			// some of the values will be pointers, and some won't. The reflect
-			// package panics on any invalid use of values, so we need to check
-			// first.
-			if valinfo.Kind() == reflect.Ptr {
-				valfield = valinfo.Elem().Field(x)
-			} else {
-				valfield = valinfo.Field(x)
-			}
-			return setValueWithType(&valfield, value)
+		if field.Tag.Get("merge") != "" {
+			fields = append(fields, field)
 		}
 	}
 
-	return fmt.Errorf("Key not found")
+	return fields
 }
 
-func setValueWithType(field *reflect.Value, val string) error {
-	if field.CanSet() {
-		fmt.Errorf("Cannot set value %q for struct element %q", val, field.Kind().String())
+// optionPaths returns the JSON path of each option of VolumeOptions, keyed by
+// its merge tag.
+func optionPaths() map[string][]string {
+	paths := map[string][]string{}
+	collectOptionPaths(reflect.TypeOf(VolumeOptions{}), nil, paths)
+	return paths
+}
+
+func collectOptionPaths(typeinfo reflect.Type, prefix []string, paths map[string][]string) {
+	for x := 0; x < typeinfo.NumField(); x++ {
+		field := typeinfo.Field(x)
+		path := append(append([]string{}, prefix...), strings.Split(field.Tag.Get("json"), ",")[0])
+
+		if field.Type.Kind() == reflect.Struct {
+			collectOptionPaths(field.Type, path, paths)
+			continue
+		}
+
+		if key := field.Tag.Get("merge"); key != "" {
+			paths[key] = path
+		}
 	}
+}
 
-	// navigate the kinds using the reflect types. fallthrough until we can get
-	// at a convertible type. If nothing is applicable, error out.
-	switch field.Kind() {
-	case reflect.Int:
-		fallthrough
-	case reflect.Int32:
-		out, err := strconv.ParseInt(val, 10, 32)
-		if err != nil {
-			return err
+// isZero returns whether the option with the merge tag key has its zero
+// value.
+func (v *VolumeOptions) isZero(key string) bool {
+	value, _ := lookupKey(v, key)
+	return reflect.DeepEqual(value.Interface(), reflect.Zero(value.Type()).Interface())
+}
+
+// lookupKey returns the field of the options with the merge tag key.
+func lookupKey(v *VolumeOptions, key string) (reflect.Value, bool) {
+	value, _, ok := lookupField(reflect.ValueOf(v).Elem(), key)
+	return value, ok
+}
+
+// lookupField walks the struct held by valinfo, and its sub-structs, for the
+// field with the merge tag key. It returns the field's value and type
+// information.
+func lookupField(valinfo reflect.Value, key string) (reflect.Value, reflect.StructField, bool) {
+	typeinfo := valinfo.Type()
+
+	for x := 0; x < typeinfo.NumField(); x++ {
+		field := typeinfo.Field(x)
+
+		if field.Type.Kind() == reflect.Struct {
+			if value, subfield, ok := lookupField(valinfo.Field(x), key); ok {
+				return value, subfield, true
+			}
+
+			continue
 		}
 
-		field.Set(reflect.ValueOf(int(out)))
-	case reflect.Int64:
-		out, err := strconv.ParseInt(val, 10, 64)
-		if err != nil {
-			return err
+		if field.Tag.Get("merge") == key {
+			return valinfo.Field(x), field, true
 		}
+	}
 
-		field.Set(reflect.ValueOf(out))
-		return nil
-	case reflect.Uint:
-		fallthrough
-	case reflect.Uint32:
-		out, err := strconv.ParseUint(val, 10, 32)
+	return reflect.Value{}, reflect.StructField{}, false
+}
+
+func setValueWithType(field *reflect.Value, val string) error {
+	if !field.CanSet() {
+		return fmt.Errorf("Cannot set value %q for struct element %q", val, field.Kind().String())
+	}
+
+	// navigate the kinds using the reflect types. If nothing is applicable,
+	// error out.
+	switch field.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		out, err := strconv.ParseInt(val, 10, field.Type().Bits())
 		if err != nil {
 			return err
 		}
 
-		field.Set(reflect.ValueOf(uint(out)))
+		field.SetInt(out)
 		return nil
-	case reflect.Uint64:
-		out, err := strconv.ParseUint(val, 10, 64)
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		out, err := strconv.ParseUint(val, 10, field.Type().Bits())
 		if err != nil {
 			return err
 		}
 
-		field.Set(reflect.ValueOf(out))
+		field.SetUint(out)
 		return nil
 	case reflect.Bool:
 		out, err := strconv.ParseBool(val)
@@ -117,7 +167,7 @@ func setValueWithType(field *reflect.Value, val string) error {
 			return err
 		}
 
-		field.Set(reflect.ValueOf(out))
+		field.SetBool(out)
 		return nil
 	case reflect.Ptr:
 		// in this case we have a pointer; we call the Elem() method and recurse to
@@ -125,7 +175,7 @@ func setValueWithType(field *reflect.Value, val string) error {
 		ptrField := field.Elem()
 		return setValueWithType(&ptrField, val)
 	case reflect.String:
-		field.Set(reflect.ValueOf(val))
+		field.SetString(val)
 		return nil
 	}
 
@@ -11,6 +11,7 @@ func (s *configSuite) TestMerge(c *C) {
 		"snapshots":           "false",
 		"snapshots.frequency": "10m",
 		"snapshots.keep":      "20",
+		"map-mode":            "nbd",
 	}
 
 	c.Assert(mergeOpts(&v, opts), IsNil)
@@ -18,4 +19,5 @@ func (s *configSuite) TestMerge(c *C) {
 	c.Assert(v.Size, Equals, uint64(10))
 	c.Assert(v.Snapshot.Keep, Equals, uint(20))
 	c.Assert(v.Snapshot.Frequency, Equals, "10m")
+	c.Assert(v.MapMode, Equals, "nbd")
 }
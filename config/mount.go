@@ -2,90 +2,429 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"path"
+	"sort"
 	"strings"
-
-	"github.com/coreos/etcd/client"
-
-	"golang.org/x/net/context"
+	"time"
 )
 
 // MountConfig is the exchange configuration for mounts. The payload is stored
-// in etcd and used for comparison.
+// in etcd and used for comparison. Mounts are keyed by tenant and volume.
+//
+// A volume is mounted read-write on one host at a time. Volumes with the
+// shared-readonly option are instead mounted read-only on any number of
+// hosts, each of which holds a record of its own; read-write and read-only
+// mounts of a volume exclude each other.
 type MountConfig struct {
+	Tenant     string
 	Volume     string
 	Pool       string
 	MountPoint string
 	Host       string
+	ReadOnly   bool `json:",omitempty"`
+
+	// TTL is how long the record lives unless the host refreshes it. A zero
+	// TTL keeps the record until the volume is unmounted.
+	TTL time.Duration
+}
+
+func (c *TopLevelConfig) mount(tenant, name string) string {
+	return c.prefixed(rootMount, tenant, name)
 }
 
-func (c *TopLevelConfig) mount(pool, name string) string {
-	return c.prefixed(rootMount, pool, name)
+func (c *TopLevelConfig) holders(tenant, name string) string {
+	return c.prefixed(rootHolder, tenant, name)
 }
 
-// PublishMount pushes the mount to etcd.
+// mountKey returns the kind and key of the record of the mount.
+func (c *TopLevelConfig) mountKey(mt *MountConfig) (string, string) {
+	if mt.ReadOnly {
+		return rootHolder, path.Join(c.holders(mt.Tenant, mt.Volume), mt.Host)
+	}
+
+	return rootMount, c.mount(mt.Tenant, mt.Volume)
+}
+
+// PublishMount pushes the mount to etcd. It fails if the volume is mounted
+// read-write, or if the mount is read-write and the volume is mounted at
+// all.
 func (c *TopLevelConfig) PublishMount(mt *MountConfig) error {
-	content, err := json.Marshal(mt)
+	if mt.Tenant == "" || mt.Volume == "" {
+		return fmt.Errorf("Mount must name a tenant and a volume")
+	}
+
+	if mt.ReadOnly && mt.Host == "" {
+		return fmt.Errorf("Read-only mount must name a host")
+	}
+
+	// only volumes shared read-only may have more than one holder.
+	if mt.ReadOnly {
+		vc, err := c.GetVolume(mt.Tenant, mt.Volume)
+		if err != nil {
+			return err
+		}
+
+		if !vc.Options.SharedReadOnly {
+			return fmt.Errorf("Volume %q of tenant %q is not shared-readonly and cannot be mounted read-only", mt.Volume, mt.Tenant)
+		}
+	}
+
+	kind, key := c.mountKey(mt)
+
+	content, err := marshalRecord(kind, mt)
+	if err != nil {
+		return err
+	}
+
+	// hold the lock until the mount is published, so read-write and
+	// read-only mounts see each other.
+	unlock, err := c.lock(c.prefixed(rootLock, rootMount, mt.Tenant, mt.Volume), fmt.Sprintf("the mounts of volume %q", mt.Volume))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	holders, err := c.MountHolders(mt.Tenant, mt.Volume)
 	if err != nil {
 		return err
 	}
 
-	// FIXME the TTL here should be variable and there should be a way to refresh it.
-	// This way if an instance goes down, its mount expires after a while.
-	_, err = c.etcdClient.Set(context.Background(), c.mount(mt.Pool, mt.Volume), string(content), &client.SetOptions{PrevExist: client.PrevNoExist})
+	if len(holders) > 0 {
+		switch {
+		case !holders[0].ReadOnly && !mt.ReadOnly:
+			return ErrExist
+		case !holders[0].ReadOnly:
+			return fmt.Errorf("Volume %q of tenant %q is mounted read-write on host %q", mt.Volume, mt.Tenant, holders[0].Host)
+		case !mt.ReadOnly:
+			return fmt.Errorf("Volume %q of tenant %q is mounted read-only; holders are %s", mt.Volume, mt.Tenant, mountHosts(holders))
+		}
+	}
+
+	// if the host goes down, the record expires and the volume is released.
+	if _, err := c.store.Set(key, string(content), SetOptions{PrevExist: PrevNoExist, TTL: mt.TTL}); err != nil {
+		return err
+	}
+
+	// the record is written first, so that a concurrent removal of the
+	// volume either sees the mount or fails this transition.
+	if _, err := c.TransitionVolume(mt.Tenant, mt.Volume, StateMounted, ""); err != nil {
+		c.store.Delete(key, DeleteOptions{PrevValue: string(content)})
+		return err
+	}
+
+	return nil
+}
+
+// RefreshMount renews the TTL of a mount, which must match the stored mount.
+// ErrNotExist is returned if the mount expired or was removed.
+func (c *TopLevelConfig) RefreshMount(mt *MountConfig) error {
+	entry, stored, err := c.findMount(mt)
+	if err != nil {
+		return err
+	}
+
+	if !sameMount(stored, mt) {
+		return ErrCompareFailed
+	}
+
+	_, err = c.store.Set(entry.Key, entry.Value, SetOptions{PrevExist: PrevExist, PrevValue: entry.Value, TTL: mt.TTL})
 	return err
 }
 
-// RemoveMount will remove a mount from etcd. Does not fail if the mount does
-// not exist.
+// RemoveMount will remove a mount from etcd. Unless force is set, the
+// mount must match the stored mount. The volume is ready again once the last
+// mount is removed.
 func (c *TopLevelConfig) RemoveMount(mt *MountConfig, force bool) error {
-	content, err := json.Marshal(mt)
+	unlock, err := c.lock(c.prefixed(rootLock, rootMount, mt.Tenant, mt.Volume), fmt.Sprintf("the mounts of volume %q", mt.Volume))
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	opts := &client.DeleteOptions{PrevValue: string(content)}
 	if force {
-		opts = nil
+		_, key := c.mountKey(mt)
+		if entry, _, err := c.findMount(mt); err == nil {
+			key = entry.Key
+		}
+
+		if err := c.store.Delete(key, DeleteOptions{}); err != nil {
+			return err
+		}
+
+		return c.releaseVolume(mt.Tenant, mt.Volume)
 	}
 
-	_, err = c.etcdClient.Delete(context.Background(), c.mount(mt.Pool, mt.Volume), opts)
-	return err
+	entry, stored, err := c.findMount(mt)
+	if err != nil {
+		return err
+	}
+
+	if !sameMount(stored, mt) {
+		return ErrCompareFailed
+	}
+
+	if err := c.store.Delete(entry.Key, DeleteOptions{PrevValue: entry.Value}); err != nil {
+		return err
+	}
+
+	return c.releaseVolume(mt.Tenant, mt.Volume)
 }
 
-// GetMount retrieves the MountConfig for the given volume name.
-func (c *TopLevelConfig) GetMount(pool, name string) (*MountConfig, error) {
-	mt := &MountConfig{}
+// releaseVolume moves a mounted volume back to StateReady if no host holds
+// it. It must be called with the lock on the volume's mounts held. Volumes
+// which do not exist, or which left StateMounted in the meantime, e.g. to be
+// removed, are left alone.
+func (c *TopLevelConfig) releaseVolume(tenant, name string) error {
+	holders, err := c.MountHolders(tenant, name)
+	if err != nil || len(holders) > 0 {
+		return err
+	}
 
-	resp, err := c.etcdClient.Get(context.Background(), c.mount(pool, name), nil)
-	if err != nil {
+	vc, err := c.GetVolume(tenant, name)
+	if err == ErrNotExist || (err == nil && vc.State != StateMounted) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if _, err := c.TransitionVolume(tenant, name, StateReady, ""); err != nil {
+		if vc, getErr := c.GetVolume(tenant, name); getErr == nil && vc.State != StateMounted {
+			return nil
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// GetMount retrieves the read-write MountConfig for the given tenant and
+// volume name. Mounts whose TTL expired do not exist.
+func (c *TopLevelConfig) GetMount(tenant, name string) (*MountConfig, error) {
+	_, mt, err := c.findMount(&MountConfig{Tenant: tenant, Volume: name})
+	return mt, err
+}
+
+// MountHolders returns the mounts of the volume: its read-write mount, or
+// its read-only mounts ordered by host. No mounts are returned for volumes
+// which are not mounted.
+func (c *TopLevelConfig) MountHolders(tenant, name string) ([]*MountConfig, error) {
+	mt, err := c.GetMount(tenant, name)
+	switch err {
+	case nil:
+		return []*MountConfig{mt}, nil
+	case ErrNotExist:
+	default:
 		return nil, err
 	}
 
-	if err := json.Unmarshal([]byte(resp.Node.Value), mt); err != nil {
+	entries, err := c.store.List(c.holders(tenant, name))
+	if err != nil {
 		return nil, err
 	}
 
-	return mt, nil
+	holders := []*MountConfig{}
+
+	for _, entry := range entries {
+		mt := &MountConfig{}
+		if err := unmarshalRecord(rootHolder, []byte(entry.Value), mt); err != nil {
+			return nil, err
+		}
+
+		holders = append(holders, mt)
+	}
+
+	return holders, nil
+}
+
+// findMount returns the record of the mount and its stored form. Read-write
+// mounts which are still keyed by pool, as releases before mounts were keyed
+// by tenant wrote them, are found under their old key until `volcli admin
+// migrate` moves them, so that volumes mounted across an upgrade stay held.
+func (c *TopLevelConfig) findMount(mt *MountConfig) (*Entry, *MountConfig, error) {
+	kind, key := c.mountKey(mt)
+
+	entry, stored, err := c.getMount(kind, key)
+	if err == ErrNotExist && kind == rootMount {
+		return c.getLegacyMount(mt.Tenant, mt.Volume)
+	}
+
+	return entry, stored, err
+}
+
+// getLegacyMount returns the read-write mount of the volume recorded under
+// its legacy key, `pool/volume`. ErrNotExist is returned if there is none, or
+// if the record there is the mount of a volume of another tenant.
+func (c *TopLevelConfig) getLegacyMount(tenant, name string) (*Entry, *MountConfig, error) {
+	vc, err := c.GetVolume(tenant, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := c.prefixed(rootMount, vc.Options.Pool, name)
+	if key == c.mount(tenant, name) {
+		return nil, nil, ErrNotExist
+	}
+
+	entry, err := c.store.Get(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	upgraded, version, err := upgradeRecord(rootMount, []byte(entry.Value))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// a current record there is the mount of a tenant named like the pool.
+	if version == currentVersion(rootMount) {
+		return nil, nil, ErrNotExist
+	}
+
+	relocated, content, err := c.relocateMount(upgraded)
+	if err != nil || relocated != c.mount(tenant, name) {
+		return nil, nil, ErrNotExist
+	}
+
+	mt := &MountConfig{}
+	if err := json.Unmarshal(content, mt); err != nil {
+		return nil, nil, err
+	}
+
+	return entry, mt, nil
+}
+
+func (c *TopLevelConfig) getMount(kind, key string) (*Entry, *MountConfig, error) {
+	entry, err := c.store.Get(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mt := &MountConfig{}
+	if err := unmarshalRecord(kind, []byte(entry.Value), mt); err != nil {
+		return nil, nil, err
+	}
+
+	return entry, mt, nil
+}
+
+// sameMount compares mounts. The stored form may predate the current schema,
+// so mounts are compared rather than their encoding. The TTL is how long the
+// record lives rather than part of the mount, and is not compared.
+func sameMount(a, b *MountConfig) bool {
+	x, y := *a, *b
+	x.TTL, y.TTL = 0, 0
+	return x == y
+}
+
+func mountHosts(holders []*MountConfig) string {
+	hosts := []string{}
+	for _, mt := range holders {
+		hosts = append(hosts, mt.Host)
+	}
+
+	return quoteList(hosts)
 }
 
-// ListMounts lists the mounts in use.
+// ListMounts lists the volumes which are mounted, read-write or read-only,
+// as `tenant/volume`.
 func (c *TopLevelConfig) ListMounts() ([]string, error) {
-	resp, err := c.etcdClient.Get(context.Background(), c.prefixed(rootMount), &client.GetOptions{Sort: true, Recursive: true})
+	ret := []string{}
+
+	entries, err := c.store.List(c.prefixed(rootMount))
 	if err != nil {
 		return nil, err
 	}
 
-	ret := []string{}
+	for _, entry := range entries {
+		ret = append(ret, c.relative(rootMount, entry))
+	}
+
+	entries, err = c.store.List(c.prefixed(rootHolder))
+	if err != nil {
+		return nil, err
+	}
 
-	for _, node := range resp.Node.Nodes {
-		for _, inner := range node.Nodes {
-			key := path.Join(strings.TrimPrefix(inner.Key, c.prefixed(rootMount)))
-			// trim leading slash
-			key = key[1:]
-			ret = append(ret, key)
+	seen := map[string]bool{}
+
+	for _, entry := range entries {
+		volume := path.Dir(c.relative(rootHolder, entry))
+		if !seen[volume] {
+			seen[volume] = true
+			ret = append(ret, volume)
 		}
 	}
 
+	sort.Strings(ret)
+
 	return ret, nil
 }
+
+// addMountTenant is the migration to records keyed by tenant. Records written
+// before it have no tenant; it is recovered from the mount point, which ends
+// in the `tenant.volume` image name for volumes which were not adopted.
+// relocateMount finds the tenant of the others and moves the records.
+func addMountTenant(record map[string]interface{}) error {
+	volume, _ := record["Volume"].(string)
+	mountPoint, _ := record["MountPoint"].(string)
+
+	image := path.Base(mountPoint)
+	if volume != "" && strings.HasSuffix(image, "."+volume) {
+		record["Tenant"] = strings.TrimSuffix(image, "."+volume)
+	}
+
+	return nil
+}
+
+// relocateMount returns the key an upgraded mount record belongs at, and the
+// record to write there. Records without a tenant get the tenant of the
+// volume in the same pool which is backed by the mounted image.
+func (c *TopLevelConfig) relocateMount(content []byte) (string, []byte, error) {
+	mt := &MountConfig{}
+	if err := json.Unmarshal(content, mt); err != nil {
+		return "", nil, err
+	}
+
+	if mt.Tenant == "" {
+		tenant, err := c.findMountTenant(mt)
+		if err != nil {
+			return "", nil, err
+		}
+
+		mt.Tenant = tenant
+
+		content, err = marshalRecord(rootMount, mt)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	return c.mount(mt.Tenant, mt.Volume), content, nil
+}
+
+func (c *TopLevelConfig) findMountTenant(mt *MountConfig) (string, error) {
+	tenants, err := c.ListTenants()
+	if err != nil {
+		return "", err
+	}
+
+	found := []string{}
+
+	for _, tenant := range tenants {
+		vc, err := c.GetVolume(tenant, mt.Volume)
+		if err != nil {
+			continue
+		}
+
+		if vc.Options.Pool == mt.Pool && vc.ImageName() == path.Base(mt.MountPoint) {
+			found = append(found, tenant)
+		}
+	}
+
+	if len(found) != 1 {
+		return "", fmt.Errorf("Cannot determine the tenant of the mount of volume %q in pool %q", mt.Volume, mt.Pool)
+	}
+
+	return found[0], nil
+}
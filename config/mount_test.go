@@ -2,18 +2,21 @@ package config
 
 import (
 	"sort"
+	"time"
 
 	. "gopkg.in/check.v1"
 )
 
 var testMountConfigs = map[string]*MountConfig{
 	"basic": {
+		Tenant:     "foo",
 		Volume:     "quux",
 		Pool:       "rbd",
 		MountPoint: "/tmp/mountpoint",
 		Host:       "hostname",
 	},
 	"basic2": {
+		Tenant:     "bar",
 		Volume:     "baz",
 		Pool:       "rbd",
 		MountPoint: "/tmp/mountpoint",
@@ -21,13 +24,27 @@ var testMountConfigs = map[string]*MountConfig{
 	},
 }
 
+// readyVolume creates a volume which is ready to be mounted.
+func (s *configSuite) readyVolume(c *C, tenant, volume string) {
+	c.Assert(s.tlc.PublishTenant(tenant, testTenantConfigs["basic"]), IsNil)
+
+	_, err := s.tlc.CreateVolume(RequestCreate{Tenant: tenant, Volume: volume})
+	c.Assert(err, IsNil)
+
+	_, err = s.tlc.TransitionVolume(tenant, volume, StateReady, "")
+	c.Assert(err, IsNil)
+}
+
 func (s *configSuite) TestMountCRUD(c *C) {
+	s.readyVolume(c, "foo", "quux")
+	s.readyVolume(c, "bar", "baz")
+
 	c.Assert(s.tlc.PublishMount(testMountConfigs["basic"]), IsNil)
 	c.Assert(s.tlc.PublishMount(testMountConfigs["basic"]), NotNil)
 	c.Assert(s.tlc.RemoveMount(testMountConfigs["basic"], false), IsNil)
 	c.Assert(s.tlc.PublishMount(testMountConfigs["basic"]), IsNil)
 
-	mt, err := s.tlc.GetMount("rbd", "quux")
+	mt, err := s.tlc.GetMount("foo", "quux")
 	c.Assert(err, IsNil)
 	c.Assert(testMountConfigs["basic"], DeepEquals, mt)
 
@@ -36,7 +53,7 @@ func (s *configSuite) TestMountCRUD(c *C) {
 	c.Assert(s.tlc.RemoveMount(testMountConfigs["basic2"], false), IsNil)
 	c.Assert(s.tlc.PublishMount(testMountConfigs["basic2"]), IsNil)
 
-	mt, err = s.tlc.GetMount("rbd", "baz")
+	mt, err = s.tlc.GetMount("bar", "baz")
 	c.Assert(err, IsNil)
 	c.Assert(testMountConfigs["basic2"], DeepEquals, mt)
 
@@ -44,5 +61,166 @@ func (s *configSuite) TestMountCRUD(c *C) {
 	c.Assert(err, IsNil)
 
 	sort.Strings(mounts)
-	c.Assert([]string{"rbd/baz", "rbd/quux"}, DeepEquals, mounts)
+	c.Assert([]string{"bar/baz", "foo/quux"}, DeepEquals, mounts)
+
+	c.Assert(s.tlc.PublishMount(&MountConfig{Volume: "quux", Pool: "rbd"}), NotNil)
+}
+
+func (s *configSuite) TestMountTTL(c *C) {
+	mt := *testMountConfigs["basic"]
+	mt.TTL = 200 * time.Millisecond
+
+	s.readyVolume(c, "foo", "quux")
+	c.Assert(s.tlc.PublishMount(&mt), IsNil)
+
+	// refreshing keeps the mount past its original TTL.
+	for i := 0; i < 3; i++ {
+		time.Sleep(100 * time.Millisecond)
+		c.Assert(s.tlc.RefreshMount(&mt), IsNil)
+	}
+
+	_, err := s.tlc.GetMount("foo", "quux")
+	c.Assert(err, IsNil)
+
+	other := mt
+	other.Host = "otherhost"
+	c.Assert(s.tlc.RefreshMount(&other), Equals, ErrCompareFailed)
+	c.Assert(s.tlc.PublishMount(&other), Equals, ErrExist)
+
+	// an expired mount is released, so another host may take it.
+	time.Sleep(300 * time.Millisecond)
+	_, err = s.tlc.GetMount("foo", "quux")
+	c.Assert(err, Equals, ErrNotExist)
+	c.Assert(s.tlc.RefreshMount(&mt), Equals, ErrNotExist)
+	c.Assert(s.tlc.PublishMount(&other), IsNil)
+
+	// the TTL is not part of the mount.
+	other.TTL = 0
+	c.Assert(s.tlc.RemoveMount(&other, false), IsNil)
+}
+
+func (s *configSuite) TestMountSharedReadOnly(c *C) {
+	ro := func(host string) *MountConfig {
+		return &MountConfig{Tenant: "foo", Volume: "quux", Pool: "rbd", MountPoint: "/tmp/mountpoint", Host: host, ReadOnly: true}
+	}
+
+	s.readyVolume(c, "foo", "quux")
+
+	c.Assert(s.tlc.PublishMount(ro("host1")), ErrorMatches, `Volume "quux" of tenant "foo" is not shared-readonly and cannot be mounted read-only`)
+
+	_, err := s.tlc.UpdateVolume(RequestUpdate{Tenant: "foo", Volume: "quux", Opts: map[string]string{"shared-readonly": "true"}})
+	c.Assert(err, IsNil)
+
+	for _, host := range []string{"host2", "host1"} {
+		c.Assert(s.tlc.PublishMount(ro(host)), IsNil)
+	}
+
+	c.Assert(s.tlc.PublishMount(ro("host1")), Equals, ErrExist)
+	c.Assert(s.tlc.PublishMount(testMountConfigs["basic"]), ErrorMatches, `Volume "quux" of tenant "foo" is mounted read-only; holders are "host1", "host2"`)
+
+	holders, err := s.tlc.MountHolders("foo", "quux")
+	c.Assert(err, IsNil)
+	c.Assert(holders, DeepEquals, []*MountConfig{ro("host1"), ro("host2")})
+
+	_, err = s.tlc.GetMount("foo", "quux")
+	c.Assert(err, Equals, ErrNotExist)
+
+	mounts, err := s.tlc.ListMounts()
+	c.Assert(err, IsNil)
+	c.Assert(mounts, DeepEquals, []string{"foo/quux"})
+
+	c.Assert(s.tlc.RefreshMount(ro("host1")), IsNil)
+	c.Assert(s.tlc.RemoveMount(ro("host1"), false), IsNil)
+	c.Assert(s.tlc.RemoveMount(ro("host2"), false), IsNil)
+
+	holders, err = s.tlc.MountHolders("foo", "quux")
+	c.Assert(err, IsNil)
+	c.Assert(holders, HasLen, 0)
+
+	c.Assert(s.tlc.PublishMount(testMountConfigs["basic"]), IsNil)
+	c.Assert(s.tlc.PublishMount(ro("host1")), ErrorMatches, `Volume "quux" of tenant "foo" is mounted read-write on host "hostname"`)
+
+	holders, err = s.tlc.MountHolders("foo", "quux")
+	c.Assert(err, IsNil)
+	c.Assert(holders, DeepEquals, []*MountConfig{testMountConfigs["basic"]})
+}
+
+func (s *configSuite) TestMountMigration(c *C) {
+	c.Assert(s.tlc.PublishTenant("foo", testTenantConfigs["basic"]), IsNil)
+	c.Assert(s.tlc.PublishTenant("bar", testTenantConfigs["basic"]), IsNil)
+
+	_, err := s.tlc.AdoptVolume(RequestCreate{Tenant: "bar", Volume: "db"}, "legacy")
+	c.Assert(err, IsNil)
+
+	// mounts written by older releases are keyed by pool and bare volume
+	// name. The tenant of foo/db shows in the image name; bar/db was adopted,
+	// so its tenant is found from the volume backed by the image. Mounts kept
+	// alive by a volplugin expire, and keep doing so once moved.
+	_, err = s.tlc.store.Set(s.tlc.prefixed(rootMount, "rbd", "db"), `{"Volume":"db","Pool":"rbd","MountPoint":"/mnt/ceph/rbd/foo.db","Host":"host1"}`, SetOptions{TTL: time.Minute})
+	c.Assert(err, IsNil)
+	_, err = s.tlc.store.Set(s.tlc.prefixed(rootMount, "rbd2", "db"), `{"Volume":"db","Pool":"rbd","MountPoint":"/mnt/ceph/rbd/legacy","Host":"host2"}`, SetOptions{})
+	c.Assert(err, IsNil)
+
+	pending, err := s.tlc.MigrateRecords(false)
+	c.Assert(err, IsNil)
+	c.Assert(pending, HasLen, 2)
+
+	mounts, err := s.tlc.ListMounts()
+	c.Assert(err, IsNil)
+	sort.Strings(mounts)
+	c.Assert(mounts, DeepEquals, []string{"bar/db", "foo/db"})
+
+	mt, err := s.tlc.GetMount("foo", "db")
+	c.Assert(err, IsNil)
+	c.Assert(mt, DeepEquals, &MountConfig{Tenant: "foo", Volume: "db", Pool: "rbd", MountPoint: "/mnt/ceph/rbd/foo.db", Host: "host1"})
+
+	mt, err = s.tlc.GetMount("bar", "db")
+	c.Assert(err, IsNil)
+	c.Assert(mt.Host, Equals, "host2")
+
+	entry, err := s.tlc.store.Get(s.tlc.mount("foo", "db"))
+	c.Assert(err, IsNil)
+	c.Assert(entry.TTL > 0 && entry.TTL <= time.Minute, Equals, true)
+
+	entry, err = s.tlc.store.Get(s.tlc.mount("bar", "db"))
+	c.Assert(err, IsNil)
+	c.Assert(entry.TTL, Equals, time.Duration(0))
+
+	// a mount whose tenant cannot be found is left alone.
+	orphan := `{"Volume":"gone","Pool":"rbd","MountPoint":"/mnt/ceph/rbd/other","Host":"host1"}`
+	_, err = s.tlc.store.Set(s.tlc.prefixed(rootMount, "rbd", "gone"), orphan, SetOptions{})
+	c.Assert(err, IsNil)
+
+	_, err = s.tlc.MigrateRecords(false)
+	c.Assert(err, ErrorMatches, `.*Cannot determine the tenant of the mount of volume "gone" in pool "rbd"`)
+
+	entry, err = s.tlc.store.Get(s.tlc.prefixed(rootMount, "rbd", "gone"))
+	c.Assert(err, IsNil)
+	c.Assert(entry.Value, Equals, orphan)
+}
+
+func (s *configSuite) TestMountLegacyKey(c *C) {
+	s.readyVolume(c, "foo", "db")
+
+	// mounts made before an upgrade hold the volume until they are migrated.
+	legacy := s.tlc.prefixed(rootMount, "rbd", "db")
+	_, err := s.tlc.store.Set(legacy, `{"Volume":"db","Pool":"rbd","MountPoint":"/mnt/ceph/rbd/foo.db","Host":"host1"}`, SetOptions{})
+	c.Assert(err, IsNil)
+
+	mt := &MountConfig{Tenant: "foo", Volume: "db", Pool: "rbd", MountPoint: "/mnt/ceph/rbd/foo.db", Host: "host2"}
+	c.Assert(s.tlc.PublishMount(mt), Equals, ErrExist)
+
+	holders, err := s.tlc.MountHolders("foo", "db")
+	c.Assert(err, IsNil)
+	c.Assert(holders, DeepEquals, []*MountConfig{{Tenant: "foo", Volume: "db", Pool: "rbd", MountPoint: "/mnt/ceph/rbd/foo.db", Host: "host1"}})
+
+	_, err = s.tlc.TransitionVolume("foo", "db", StateRemoving, "")
+	c.Assert(err, ErrorMatches, `Volume "db" of tenant "foo" is mounted on host "host1"`)
+
+	// the host which made the mount can release it.
+	c.Assert(s.tlc.RemoveMount(holders[0], false), IsNil)
+	_, err = s.tlc.store.Get(legacy)
+	c.Assert(err, Equals, ErrNotExist)
+
+	c.Assert(s.tlc.PublishMount(mt), IsNil)
 }
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxNameLength bounds tenant and volume names, so the image name built from
+// them stays well within what RBD accepts.
+const maxNameLength = 64
+
+// nameEscapes are the escape sequences used in image names. `.` separates the
+// tenant from the volume, so it may not appear in either escaped name; `_`
+// is the escape character itself.
+var nameEscapes = strings.NewReplacer("_", "__", ".", "_d")
+
+// ValidateName checks a tenant or volume name. Names are 1 to 64 characters
+// of letters, digits, `-`, `_` and `.`, and must start with a letter or a
+// digit. kind names the kind of name in the error, e.g. "tenant".
+func ValidateName(kind, name string) error {
+	if name == "" {
+		return fmt.Errorf("Invalid %s name: the name is empty", kind)
+	}
+
+	if len(name) > maxNameLength {
+		return fmt.Errorf("Invalid %s name %q: names may be at most %d characters", kind, name, maxNameLength)
+	}
+
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case i == 0:
+			return fmt.Errorf("Invalid %s name %q: names must start with a letter or a digit", kind, name)
+		case r == '-', r == '_', r == '.':
+		default:
+			return fmt.Errorf("Invalid %s name %q: %q is not allowed, use letters, digits, '-', '_' and '.'", kind, name, r)
+		}
+	}
+
+	return nil
+}
+
+// ImageName returns the name of the RBD image for a new volume. The tenant
+// and volume names are escaped so that the only `.` in the result separates
+// them, which makes the name unambiguous: tenant `a.b` with volume `c` and
+// tenant `a` with volume `b.c` get different images.
+func ImageName(tenant, volume string) string {
+	return nameEscapes.Replace(tenant) + "." + nameEscapes.Replace(volume)
+}
+
+// legacyImageName returns the image name used for volumes created before
+// image names were escaped and recorded.
+func legacyImageName(tenant, volume string) string {
+	return tenant + "." + volume
+}
+
+// CheckImage returns an error if a volume of any tenant already uses image in
+// pool, including the legacy image of a volume which recorded none.
+func (c *TopLevelConfig) CheckImage(pool, image string) error {
+	entries, err := c.store.List(c.prefixed(rootVolume))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		vc := &VolumeConfig{}
+		if err := unmarshalRecord(rootVolume, []byte(entry.Value), vc); err != nil {
+			return err
+		}
+
+		if vc.Options != nil && vc.Options.Pool == pool && vc.ImageName() == image {
+			return fmt.Errorf("Image %q in pool %q is already used by volume %q in tenant %q", image, pool, vc.VolumeName, vc.TenantName)
+		}
+	}
+
+	return nil
+}
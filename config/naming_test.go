@@ -0,0 +1,71 @@
+package config
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *configSuite) TestValidateName(c *C) {
+	for _, name := range []string{"foo", "foo.bar", "foo_bar", "foo-bar", "0", strings.Repeat("a", maxNameLength)} {
+		c.Assert(ValidateName("volume", name), IsNil, Commentf("%q", name))
+	}
+
+	for _, name := range []string{"", ".foo", "-foo", "_foo", "foo/bar", "foo@snap", "foo bar", "föo", strings.Repeat("a", maxNameLength+1)} {
+		c.Assert(ValidateName("volume", name), NotNil, Commentf("%q", name))
+	}
+
+	c.Assert(ValidateName("tenant", "foo@bar"), ErrorMatches, `Invalid tenant name "foo@bar": '@' is not allowed.*`)
+}
+
+func (s *configSuite) TestImageName(c *C) {
+	c.Assert(ImageName("foo", "bar"), Equals, "foo.bar")
+	c.Assert(ImageName("a.b", "c"), Equals, "a_db.c")
+	c.Assert(ImageName("a", "b.c"), Equals, "a.b_dc")
+	c.Assert(ImageName("a_d", "c"), Equals, "a__d.c")
+	c.Assert(ImageName("a.", "c"), Not(Equals), ImageName("a_", "dc"))
+
+	c.Assert(s.tlc.PublishTenant("a.b", testTenantConfigs["basic"]), IsNil)
+	c.Assert(s.tlc.PublishTenant("a", testTenantConfigs["basic"]), IsNil)
+	c.Assert(s.tlc.PublishTenant("foo/bar", testTenantConfigs["basic"]), NotNil)
+
+	vc1, err := s.tlc.CreateVolume(RequestCreate{Tenant: "a.b", Volume: "c"})
+	c.Assert(err, IsNil)
+	vc2, err := s.tlc.CreateVolume(RequestCreate{Tenant: "a", Volume: "b.c"})
+	c.Assert(err, IsNil)
+	c.Assert(vc1.ImageName(), Not(Equals), vc2.ImageName())
+
+	_, err = s.tlc.CreateVolume(RequestCreate{Tenant: "a", Volume: "b@c"})
+	c.Assert(err, NotNil)
+}
+
+func (s *configSuite) TestImageNameLegacy(c *C) {
+	c.Assert(s.tlc.PublishTenant("foo", testTenantConfigs["basic"]), IsNil)
+
+	// records written before image names were recorded have none.
+	old := `{"tenant":"foo","name":"bar_dbaz","options":{"pool":"rbd","size":10,"filesystem":"ext4"}}`
+	_, err := s.tlc.store.Set(s.tlc.volume("foo", "bar_dbaz"), old, SetOptions{})
+	c.Assert(err, IsNil)
+
+	vc, err := s.tlc.GetVolume("foo", "bar_dbaz")
+	c.Assert(err, IsNil)
+	c.Assert(vc.ImageName(), Equals, "foo.bar_dbaz")
+
+	// the escaped name of foo/bar.baz is the legacy image of foo/bar_dbaz.
+	_, err = s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "bar.baz"})
+	c.Assert(err, ErrorMatches, `Image "foo.bar_dbaz" in pool "rbd" is already used by volume "bar_dbaz" in tenant "foo"`)
+
+	vc, err = s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "quux"})
+	c.Assert(err, IsNil)
+	c.Assert(vc.Image, Equals, "foo.quux")
+
+	// volumes named before the current rules still exist when docker creates
+	// them again.
+	old = `{"tenant":"foo","name":"bar baz","options":{"pool":"rbd","size":10,"filesystem":"ext4"}}`
+	_, err = s.tlc.store.Set(s.tlc.volume("foo", "bar baz"), old, SetOptions{})
+	c.Assert(err, IsNil)
+
+	vc, err = s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "bar baz"})
+	c.Assert(err, Equals, ErrExist)
+	c.Assert(vc.VolumeName, Equals, "bar baz")
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// OptionPolicy restricts how users may override an option of the tenant's
+// default options when they create a volume. Min and Max apply to numeric
+// options and are ignored when zero; Allowed lists the permitted values of
+// any other option.
+type OptionPolicy struct {
+	Locked  bool     `json:"locked,omitempty"`
+	Min     uint64   `json:"min,omitempty"`
+	Max     uint64   `json:"max,omitempty"`
+	Allowed []string `json:"allowed,omitempty"`
+
+	// minText and maxText hold bounds given as strings until parseBounds
+	// parses them.
+	minText string
+	maxText string
+}
+
+// validatePolicy ensures each policy names an existing option and only uses
+// bounds on numeric options.
+func (cfg *TenantConfig) validatePolicy() error {
+	for key, policy := range cfg.Policy {
+		value, ok := lookupKey(&cfg.DefaultVolumeOptions, key)
+		if !ok {
+			return fmt.Errorf("Policy for unknown option %q", key)
+		}
+
+		if (policy.Min != 0 || policy.Max != 0) && !isNumeric(value) {
+			return fmt.Errorf("Policy for option %q has bounds, but the option is not a number", key)
+		}
+
+		if policy.Max != 0 && policy.Min > policy.Max {
+			return fmt.Errorf("Policy for option %q has a minimum larger than its maximum", key)
+		}
+	}
+
+	return nil
+}
+
+// checkPolicy checks the options a user supplied, as merged into opts,
+// against the tenant's policy.
+func (cfg *TenantConfig) checkPolicy(tenant string, requested map[string]string, opts *VolumeOptions) error {
+	for key := range requested {
+		if policy, ok := cfg.Policy[key]; ok && policy.Locked {
+			return fmt.Errorf("Option %q may not be overridden for tenant %q", key, tenant)
+		}
+
+		if err := cfg.checkPolicyValue(tenant, key, opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkPolicyValue checks the value of option key in opts against the bounds
+// and allowed values of the tenant's policy. Unlike checkPolicy it does not
+// refuse locked options, as tenant-apply sets them from the tenant itself.
+func (cfg *TenantConfig) checkPolicyValue(tenant, key string, opts *VolumeOptions) error {
+	policy, ok := cfg.Policy[key]
+	if !ok {
+		return nil
+	}
+
+	value, ok := lookupKey(opts, key)
+	if !ok {
+		return nil
+	}
+
+	if isNumeric(value) {
+		num := numericValue(value)
+
+		if (policy.Min != 0 && num < policy.Min) || (policy.Max != 0 && num > policy.Max) {
+			return fmt.Errorf("Option %q value %d is not allowed for tenant %q; it must be %s", key, num, tenant, policy.describeBounds())
+		}
+	}
+
+	if len(policy.Allowed) > 0 {
+		str := fmt.Sprint(value.Interface())
+
+		for _, candidate := range policy.Allowed {
+			if candidate == str {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("Option %q value %q is not allowed for tenant %q; allowed values are %s", key, str, tenant, quoteList(policy.Allowed))
+	}
+
+	return nil
+}
+
+func (policy *OptionPolicy) describeBounds() string {
+	switch {
+	case policy.Min != 0 && policy.Max != 0:
+		return fmt.Sprintf("between %d and %d", policy.Min, policy.Max)
+	case policy.Min != 0:
+		return fmt.Sprintf("at least %d", policy.Min)
+	default:
+		return fmt.Sprintf("at most %d", policy.Max)
+	}
+}
+
+func isNumeric(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+
+	return false
+}
+
+func numericValue(value reflect.Value) uint64 {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		if value.Int() < 0 {
+			return 0
+		}
+
+		return uint64(value.Int())
+	}
+
+	return value.Uint()
+}
+
+func quoteList(values []string) string {
+	quoted := []string{}
+	for _, value := range values {
+		quoted = append(quoted, fmt.Sprintf("%q", value))
+	}
+
+	return strings.Join(quoted, ", ")
+}
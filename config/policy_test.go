@@ -0,0 +1,85 @@
+package config
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *configSuite) TestTenantPolicy(c *C) {
+	tc := &TenantConfig{
+		DefaultVolumeOptions: VolumeOptions{Pool: "rbd", Size: 10, FileSystem: defaultFilesystem},
+		FileSystems:          defaultFilesystems,
+	}
+
+	for _, policy := range []map[string]*OptionPolicy{
+		{"quux": {Locked: true}},
+		{"filesystem": {Max: 10}},
+		{"size": {Min: 20, Max: 10}},
+	} {
+		tc.Policy = policy
+		c.Assert(s.tlc.PublishTenant("foo", tc), NotNil)
+	}
+
+	tc.Policy = map[string]*OptionPolicy{
+		"pool":                  {Locked: true},
+		"size":                  {Min: 5, Max: 100},
+		"rate-limit.write.iops": {Max: 1000},
+		"filesystem":            {Allowed: []string{"ext4", "btrfs"}},
+		"snapshots":             {Allowed: []string{"false"}},
+	}
+
+	c.Assert(s.tlc.PublishTenant("foo", tc), IsNil)
+
+	c.Assert(s.getTenant(c, "foo"), DeepEquals, tc)
+
+	for msg, opts := range map[string]map[string]string{
+		`Option "pool" may not be overridden for tenant "foo"`:                                                {"pool": "other"},
+		`Option "size" value 200 is not allowed for tenant "foo"; it must be between 5 and 100`:               {"size": "200"},
+		`Option "size" value 1 is not allowed for tenant "foo"; it must be between 5 and 100`:                 {"size": "1"},
+		`Option "rate-limit.write.iops" value 2000 is not allowed for tenant "foo"; it must be at most 1000`:  {"rate-limit.write.iops": "2000"},
+		`Option "filesystem" value "xfs" is not allowed for tenant "foo"; allowed values are "ext4", "btrfs"`: {"filesystem": "xfs"},
+		`Option "snapshots" value "true" is not allowed for tenant "foo"; allowed values are "false"`:         {"snapshots": "true"},
+	} {
+		_, err := s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "bar", Opts: opts})
+		c.Assert(err, ErrorMatches, msg)
+	}
+
+	vc, err := s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "bar", Opts: map[string]string{
+		"size":                  "50",
+		"filesystem":            "btrfs",
+		"rate-limit.write.iops": "500",
+		"rate-limit.read.iops":  "5000",
+	}})
+	c.Assert(err, IsNil)
+	defer s.tlc.RemoveVolume("foo", "bar", 0)
+	c.Assert(vc.Options.FileSystem, Equals, "btrfs")
+
+	// the defaults apply regardless of the policy.
+	_, err = s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "baz"})
+	c.Assert(err, IsNil)
+	defer s.tlc.RemoveVolume("foo", "baz", 0)
+
+	// updates are held to the same policy.
+	for msg, opts := range map[string]map[string]string{
+		`Option "rate-limit.write.iops" value 2000 is not allowed for tenant "foo"; it must be at most 1000`: {"rate-limit.write.iops": "2000"},
+		`Option "snapshots" value "true" is not allowed for tenant "foo"; allowed values are "false"`:        {"snapshots": "true"},
+	} {
+		_, err := s.tlc.UpdateVolume(RequestUpdate{Tenant: "foo", Volume: "baz", Opts: opts})
+		c.Assert(err, ErrorMatches, msg)
+	}
+
+	_, err = s.tlc.UpdateVolume(RequestUpdate{Tenant: "foo", Volume: "baz", Opts: map[string]string{"rate-limit.write.iops": "800"}})
+	c.Assert(err, IsNil)
+
+	// so are the values tenant-apply would copy from the tenant.
+	tc.DefaultVolumeOptions.RateLimit.ReadIOPS = 2000
+	tc.Policy["rate-limit.read.iops"] = &OptionPolicy{Max: 1000}
+	c.Assert(s.tlc.PublishTenant("foo", tc), IsNil)
+
+	_, err = s.tlc.PlanTenantApply("foo", []string{"rate-limit"})
+	c.Assert(err, ErrorMatches, `Volume "baz": Option "rate-limit.read.iops" value 2000 is not allowed for tenant "foo"; it must be at most 1000`)
+
+	// adopted volumes take their options from the image.
+	_, err = s.tlc.AdoptVolume(RequestCreate{Tenant: "foo", Volume: "quux", Opts: map[string]string{"pool": "other", "size": "200"}}, "legacy")
+	c.Assert(err, IsNil)
+	defer s.tlc.RemoveVolume("foo", "quux", 0)
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// profileKey is the driver option which selects a profile of the tenant.
+const profileKey = "profile"
+
+// splitProfile separates the profile selected in the options of a request
+// from the rest of the options.
+func splitProfile(opts map[string]string) (string, map[string]string) {
+	profile := ""
+	rest := map[string]string{}
+
+	for key, value := range opts {
+		if key == profileKey {
+			profile = value
+			continue
+		}
+
+		rest[key] = value
+	}
+
+	return profile, rest
+}
+
+// applyProfile overlays the options of the named profile onto opts.
+func (cfg *TenantConfig) applyProfile(tenant, profile string, opts *VolumeOptions) error {
+	if profile == "" {
+		return nil
+	}
+
+	profileOpts, ok := cfg.Profiles[profile]
+	if !ok {
+		if len(cfg.Profiles) == 0 {
+			return fmt.Errorf("Unknown profile %q: tenant %q has no profiles", profile, tenant)
+		}
+
+		return fmt.Errorf("Unknown profile %q for tenant %q; profiles are %s", profile, tenant, quoteList(cfg.ProfileNames()))
+	}
+
+	if err := mergeOpts(opts, profileOpts); err != nil {
+		return fmt.Errorf("Profile %q: %v", profile, err)
+	}
+
+	return nil
+}
+
+// ProfileNames returns the names of the tenant's profiles, sorted.
+func (cfg *TenantConfig) ProfileNames() []string {
+	names := []string{}
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// validateProfiles ensures each profile yields valid options when overlaid
+// onto the default options.
+func (cfg *TenantConfig) validateProfiles() error {
+	for name := range cfg.Profiles {
+		if name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("Invalid profile name %q", name)
+		}
+
+		opts := cfg.DefaultVolumeOptions
+		if err := cfg.applyProfile("", name, &opts); err != nil {
+			return err
+		}
+
+		if err := opts.Validate(); err != nil {
+			return fmt.Errorf("Profile %q: %v", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *configSuite) TestTenantProfiles(c *C) {
+	tc := &TenantConfig{
+		DefaultVolumeOptions: VolumeOptions{Pool: "rbd", Size: 10, FileSystem: defaultFilesystem},
+		FileSystems:          defaultFilesystems,
+	}
+
+	for _, profiles := range []map[string]map[string]string{
+		{"bad": {"quux": "1"}},
+		{"bad": {"size": "0"}},
+		{"": {"size": "10"}},
+	} {
+		tc.Profiles = profiles
+		c.Assert(s.tlc.PublishTenant("foo", tc), NotNil)
+	}
+
+	_, err := s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "bar", Opts: map[string]string{"profile": "fast"}})
+	c.Assert(err, NotNil)
+
+	tc.Profiles = map[string]map[string]string{
+		"fast":    {"pool": "ssd", "rate-limit.write.iops": "10000"},
+		"bulk":    {"pool": "hdd", "size": "1000"},
+		"scratch": {"ephemeral": "true"},
+	}
+	tc.Policy = map[string]*OptionPolicy{"pool": {Locked: true}}
+
+	c.Assert(s.tlc.PublishTenant("foo", tc), IsNil)
+	c.Assert(tc.ProfileNames(), DeepEquals, []string{"bulk", "fast", "scratch"})
+
+	_, err = s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "bar", Opts: map[string]string{"profile": "quux"}})
+	c.Assert(err, ErrorMatches, `Unknown profile "quux" for tenant "foo"; profiles are "bulk", "fast", "scratch"`)
+
+	// profiles are not subject to the policy, which only restricts users.
+	vc, err := s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "bar", Opts: map[string]string{"profile": "fast", "rate-limit.read.iops": "500"}})
+	c.Assert(err, IsNil)
+	defer s.tlc.RemoveVolume("foo", "bar", 0)
+
+	c.Assert(vc.Profile, Equals, "fast")
+	c.Assert(vc.Overrides, DeepEquals, []string{"rate-limit.read.iops"})
+	c.Assert(vc.Options.Pool, Equals, "ssd")
+	c.Assert(vc.Options.Size, Equals, uint64(10))
+	c.Assert(vc.Options.RateLimit, DeepEquals, RateLimitConfig{WriteIOPS: 10000, ReadIOPS: 500})
+
+	// options given by the user take precedence over the profile.
+	vc, err = s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "baz", Opts: map[string]string{"profile": "bulk", "size": "20"}})
+	c.Assert(err, IsNil)
+	defer s.tlc.RemoveVolume("foo", "baz", 0)
+	c.Assert(vc.Options.Size, Equals, uint64(20))
+
+	_, err = s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "quux", Opts: map[string]string{"profile": "bulk", "pool": "other"}})
+	c.Assert(err, NotNil)
+
+	// applying the tenant keeps the values of the volume's profile.
+	tc.Profiles["fast"]["rate-limit.write.iops"] = "20000"
+	c.Assert(s.tlc.PublishTenant("foo", tc), IsNil)
+
+	plan, err := s.tlc.PlanTenantApply("foo", []string{"rate-limit"})
+	c.Assert(err, IsNil)
+	c.Assert(plan, HasLen, 1)
+	c.Assert(plan[0].Volume, Equals, "bar")
+	c.Assert(plan[0].Changes, DeepEquals, []OptionChange{{Key: "rate-limit.write.iops", Old: "10000", New: "20000"}})
+}
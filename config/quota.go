@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	rootLock  = "locks"
+	rootImage = "images"
+
+	// lockTTL bounds how long a lock is held by a process which dies holding
+	// it, and how long taking a lock waits for it.
+	lockTTL   = 30 * time.Second
+	lockRetry = 100 * time.Millisecond
+)
+
+// QuotaConfig limits what the volumes of a tenant may consume. A zero limit
+// is unlimited. Sizes are in MB.
+type QuotaConfig struct {
+	MaxVolumes    uint   `json:"max-volumes,omitempty"`
+	MaxTotalSize  uint64 `json:"max-total-size,omitempty"`
+	MaxVolumeSize uint64 `json:"max-volume-size,omitempty"`
+	MaxSnapshots  uint   `json:"max-snapshots,omitempty"`
+}
+
+// Usage is what the volumes of a tenant currently consume. Sizes are in MB.
+type Usage struct {
+	Volumes   uint   `json:"volumes"`
+	TotalSize uint64 `json:"total-size"`
+}
+
+// TenantUsage computes the usage of a tenant from its volumes.
+func (c *TopLevelConfig) TenantUsage(tenant string) (*Usage, error) {
+	volumes, err := c.ListVolumes(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &Usage{}
+
+	for _, vc := range volumes {
+		usage.Volumes++
+		usage.TotalSize += vc.Options.Size
+	}
+
+	return usage, nil
+}
+
+// checkVolume checks the limits which apply to a single volume.
+func (q *QuotaConfig) checkVolume(tenant string, opts *VolumeOptions) error {
+	if q.MaxVolumeSize != 0 && opts.Size > q.MaxVolumeSize {
+		return fmt.Errorf("Quota exceeded for tenant %q: volume size %d MB is larger than the %d MB allowed", tenant, opts.Size, q.MaxVolumeSize)
+	}
+
+	if q.MaxSnapshots != 0 && opts.UseSnapshots && opts.Snapshot.Keep > q.MaxSnapshots {
+		return fmt.Errorf("Quota exceeded for tenant %q: keeping %d snapshots is more than the %d allowed", tenant, opts.Snapshot.Keep, q.MaxSnapshots)
+	}
+
+	return nil
+}
+
+// checkUsage checks the limits which apply to all volumes of the tenant, as
+// if a volume with opts were added to usage.
+func (q *QuotaConfig) checkUsage(tenant string, usage *Usage, opts *VolumeOptions) error {
+	if q.MaxVolumes != 0 && usage.Volumes+1 > q.MaxVolumes {
+		return fmt.Errorf("Quota exceeded for tenant %q: %d of %d volumes are in use", tenant, usage.Volumes, q.MaxVolumes)
+	}
+
+	if q.MaxTotalSize != 0 && usage.TotalSize+opts.Size > q.MaxTotalSize {
+		return fmt.Errorf("Quota exceeded for tenant %q: %d of %d MB are in use, %d MB were requested", tenant, usage.TotalSize, q.MaxTotalSize, opts.Size)
+	}
+
+	return nil
+}
+
+// lockTenant takes the lock which serializes the creation of volumes of a
+// tenant, for quota checks, and the deletion of the tenant, waiting for up to
+// lockTTL. The returned function releases it.
+func (c *TopLevelConfig) lockTenant(tenant string) (func(), error) {
+	return c.lock(c.prefixed(rootLock, rootTenant, tenant), fmt.Sprintf("tenant %q", tenant))
+}
+
+// lock takes the lock at key, waiting for up to lockTTL. what names the
+// locked object in errors. The returned function releases the lock.
+func (c *TopLevelConfig) lock(key, what string) (func(), error) {
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+
+	deadline := time.Now().Add(lockTTL)
+
+	for {
+		_, err := c.store.Set(key, owner, SetOptions{PrevExist: PrevNoExist, TTL: lockTTL})
+		switch err {
+		case nil:
+			return func() { c.store.Delete(key, DeleteOptions{PrevValue: owner}) }, nil
+		case ErrExist:
+			if time.Now().After(deadline) {
+				return nil, fmt.Errorf("Timed out waiting for the lock on %s", what)
+			}
+
+			time.Sleep(lockRetry)
+		default:
+			return nil, err
+		}
+	}
+}
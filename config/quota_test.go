@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"sync"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *configSuite) TestTenantQuota(c *C) {
+	tc := &TenantConfig{
+		DefaultVolumeOptions: VolumeOptions{Pool: "rbd", Size: 10, FileSystem: defaultFilesystem},
+		FileSystems:          defaultFilesystems,
+		Quota:                &QuotaConfig{MaxVolumeSize: 5},
+	}
+
+	c.Assert(s.tlc.PublishTenant("foo", tc), NotNil)
+
+	tc.Quota = &QuotaConfig{MaxVolumes: 3, MaxTotalSize: 40, MaxVolumeSize: 20, MaxSnapshots: 5}
+	c.Assert(s.tlc.PublishTenant("foo", tc), IsNil)
+
+	_, err := s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "big", Opts: map[string]string{"size": "30"}})
+	c.Assert(err, ErrorMatches, `Quota exceeded for tenant "foo": volume size 30 MB is larger than the 20 MB allowed`)
+
+	_, err = s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "snaps", Opts: map[string]string{
+		"snapshots":           "true",
+		"snapshots.frequency": "1h",
+		"snapshots.keep":      "10",
+	}})
+	c.Assert(err, ErrorMatches, `Quota exceeded for tenant "foo": keeping 10 snapshots .*`)
+
+	_, err = s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "a", Opts: map[string]string{"size": "20"}})
+	c.Assert(err, IsNil)
+	_, err = s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "b", Opts: map[string]string{"size": "15"}})
+	c.Assert(err, IsNil)
+
+	_, err = s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "c"})
+	c.Assert(err, ErrorMatches, `Quota exceeded for tenant "foo": 35 of 40 MB are in use, 10 MB were requested`)
+
+	usage, err := s.tlc.TenantUsage("foo")
+	c.Assert(err, IsNil)
+	c.Assert(usage, DeepEquals, &Usage{Volumes: 2, TotalSize: 35})
+
+	_, err = s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "c", Opts: map[string]string{"size": "5"}})
+	c.Assert(err, IsNil)
+
+	_, err = s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "d", Opts: map[string]string{"size": "1"}})
+	c.Assert(err, ErrorMatches, `Quota exceeded for tenant "foo": 3 of 3 volumes are in use`)
+
+	_, err = s.tlc.UpdateVolume(RequestUpdate{Tenant: "foo", Volume: "a", Opts: map[string]string{
+		"snapshots":           "true",
+		"snapshots.frequency": "1h",
+		"snapshots.keep":      "6",
+	}})
+	c.Assert(err, ErrorMatches, `Quota exceeded .*`)
+
+	for _, name := range []string{"a", "b", "c"} {
+		c.Assert(s.tlc.RemoveVolume("foo", name, 0), IsNil)
+	}
+
+	usage, err = s.tlc.TenantUsage("foo")
+	c.Assert(err, IsNil)
+	c.Assert(usage, DeepEquals, &Usage{})
+}
+
+func (s *configSuite) TestTenantQuotaConcurrent(c *C) {
+	tc := &TenantConfig{
+		DefaultVolumeOptions: VolumeOptions{Pool: "rbd", Size: 10, FileSystem: defaultFilesystem},
+		FileSystems:          defaultFilesystems,
+		Quota:                &QuotaConfig{MaxVolumes: 3},
+	}
+
+	c.Assert(s.tlc.PublishTenant("foo", tc), IsNil)
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 10)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: fmt.Sprintf("vol%d", i)})
+			errs <- err
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		if err == nil {
+			created++
+		}
+	}
+
+	c.Assert(created, Equals, 3)
+
+	usage, err := s.tlc.TenantUsage("foo")
+	c.Assert(err, IsNil)
+	c.Assert(usage.Volumes, Equals, uint(3))
+
+	volumes, err := s.tlc.ListVolumes("foo")
+	c.Assert(err, IsNil)
+	for name := range volumes {
+		c.Assert(s.tlc.RemoveVolume("foo", name, 0), IsNil)
+	}
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// layerGlobal names the global defaults in the sources ResolveTenant returns.
+// Tenants are named "tenant:<name>".
+const layerGlobal = "global"
+
+// ResolveTenant returns the effective configuration of a tenant: the global
+// defaults, overlaid with the chain of tenants the tenant extends, root first,
+// and finally the tenant itself. A value which a layer leaves out is inherited
+// from the layers below it; a value it sets, even to zero or false, overrides
+// them. The returned map names the layer
+// each value came from, keyed by option key, "filesystems.<name>",
+// "policy.<key>", "profiles.<name>" and "quota".
+func (c *TopLevelConfig) ResolveTenant(name string) (*TenantConfig, map[string]string, error) {
+	tc, err := c.GetTenant(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c.resolveTenant(name, tc, nil)
+}
+
+// resolveTenant resolves the configuration tc for the tenant name, which need
+// not be published yet. The layers in pending, keyed by the names used in
+// sources, are used in place of the stored ones.
+func (c *TopLevelConfig) resolveTenant(name string, tc *TenantConfig, pending map[string]*TenantConfig) (*TenantConfig, map[string]string, error) {
+	layers := []*TenantConfig{tc}
+	names := []string{"tenant:" + name}
+	seen := map[string]bool{name: true}
+
+	for child, parent := name, tc.Extends; parent != ""; {
+		if seen[parent] {
+			return nil, nil, fmt.Errorf("Tenant %q extends itself through %q", name, parent)
+		}
+		seen[parent] = true
+
+		var err error
+		ptc, ok := pending["tenant:"+parent]
+		if !ok {
+			ptc, err = c.GetTenant(parent)
+		}
+
+		if err == ErrNotExist {
+			return nil, nil, fmt.Errorf("Tenant %q extends %q, which does not exist", child, parent)
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		layers = append([]*TenantConfig{ptc}, layers...)
+		names = append([]string{"tenant:" + parent}, names...)
+		child, parent = parent, ptc.Extends
+	}
+
+	var err error
+	global, ok := pending[layerGlobal]
+	if !ok {
+		global, err = c.GetGlobal()
+	}
+
+	switch err {
+	case nil:
+		layers = append([]*TenantConfig{global}, layers...)
+		names = append([]string{layerGlobal}, names...)
+	case ErrNotExist:
+	default:
+		return nil, nil, err
+	}
+
+	resolved := &TenantConfig{Extends: tc.Extends}
+	sources := map[string]string{}
+
+	for i, layer := range layers {
+		resolved.overlay(layer, names[i], sources)
+	}
+
+	return resolved, sources, nil
+}
+
+// overlay applies the values set in layer onto cfg, recording them in sources
+// as coming from the layer named.
+func (cfg *TenantConfig) overlay(layer *TenantConfig, name string, sources map[string]string) {
+	for _, key := range mergeKeys(reflect.TypeOf(layer.DefaultVolumeOptions)) {
+		if !layer.setsOption(key) {
+			continue
+		}
+
+		from, _ := lookupKey(&layer.DefaultVolumeOptions, key)
+		to, _ := lookupKey(&cfg.DefaultVolumeOptions, key)
+		to.Set(from)
+		cfg.setOption(key)
+		sources[key] = name
+	}
+
+	for fs, command := range layer.FileSystems {
+		if cfg.FileSystems == nil {
+			cfg.FileSystems = map[string]string{}
+		}
+
+		cfg.FileSystems[fs] = command
+		sources["filesystems."+fs] = name
+	}
+
+	for key, policy := range layer.Policy {
+		if cfg.Policy == nil {
+			cfg.Policy = map[string]*OptionPolicy{}
+		}
+
+		cfg.Policy[key] = policy
+		sources["policy."+key] = name
+	}
+
+	for profile, opts := range layer.Profiles {
+		if cfg.Profiles == nil {
+			cfg.Profiles = map[string]map[string]string{}
+		}
+
+		cfg.Profiles[profile] = opts
+		sources["profiles."+profile] = name
+	}
+
+	if layer.Quota != nil {
+		cfg.Quota = layer.Quota
+		sources["quota"] = name
+	}
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"encoding/json"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *configSuite) TestResolveTenant(c *C) {
+	_, err := s.tlc.GetGlobal()
+	c.Assert(err, Equals, ErrNotExist)
+
+	// without global defaults or a parent, a tenant resolves to itself.
+	c.Assert(s.tlc.PublishTenant("quux", testTenantConfigs["basic"]), IsNil)
+	resolved, sources, err := s.tlc.ResolveTenant("quux")
+	c.Assert(err, IsNil)
+	c.Assert(resolved, DeepEquals, testTenantConfigs["basic"])
+	c.Assert(sources["pool"], Equals, "tenant:quux")
+
+	c.Assert(s.tlc.PublishGlobal(&TenantConfig{Extends: "quux"}), NotNil)
+
+	global := &TenantConfig{
+		DefaultVolumeOptions: VolumeOptions{
+			Pool:         "rbd",
+			UseSnapshots: true,
+			Snapshot:     SnapshotConfig{Frequency: "30m", Keep: 20},
+			RateLimit:    RateLimitConfig{WriteIOPS: 1000, ReadIOPS: 1000},
+		},
+		FileSystems: map[string]string{"ext4": "mkfs.ext4 -m0 %", "btrfs": "mkfs.btrfs %"},
+	}
+
+	c.Assert(s.tlc.PublishGlobal(global), IsNil)
+
+	got, err := s.tlc.GetGlobal()
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, global)
+
+	// a tenant which does not set its pool relies on the global defaults.
+	_, err = s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "bar"})
+	c.Assert(err, Equals, ErrNotExist)
+
+	c.Assert(s.tlc.PublishTenant("base", &TenantConfig{
+		DefaultVolumeOptions: VolumeOptions{Size: 10, Pool: "base"},
+		FileSystems:          map[string]string{"xfs": "mkfs.xfs %"},
+	}), IsNil)
+
+	c.Assert(s.tlc.PublishTenant("foo", &TenantConfig{Extends: "nonexistent"}), ErrorMatches, `Tenant "foo" extends "nonexistent", which does not exist`)
+
+	foo := &TenantConfig{
+		Extends:              "base",
+		DefaultVolumeOptions: VolumeOptions{RateLimit: RateLimitConfig{WriteIOPS: 500}},
+		Profiles:             map[string]map[string]string{"fast": {"rate-limit.write.iops": "5000"}},
+	}
+
+	c.Assert(s.tlc.PublishTenant("foo", foo), IsNil)
+
+	stored := s.getTenant(c, "foo")
+	c.Assert(stored, DeepEquals, foo)
+	c.Assert(stored.FileSystems, IsNil)
+
+	resolved, sources, err = s.tlc.ResolveTenant("foo")
+	c.Assert(err, IsNil)
+	c.Assert(resolved.Extends, Equals, "base")
+	c.Assert(resolved.DefaultVolumeOptions, DeepEquals, VolumeOptions{
+		Pool:         "base",
+		Size:         10,
+		UseSnapshots: true,
+		Snapshot:     SnapshotConfig{Frequency: "30m", Keep: 20},
+		RateLimit:    RateLimitConfig{WriteIOPS: 500, ReadIOPS: 1000},
+	})
+	c.Assert(resolved.FileSystems, DeepEquals, map[string]string{"ext4": "mkfs.ext4 -m0 %", "btrfs": "mkfs.btrfs %", "xfs": "mkfs.xfs %"})
+
+	c.Assert(sources, DeepEquals, map[string]string{
+		"pool":                  "tenant:base",
+		"size":                  "tenant:base",
+		"snapshots":             "global",
+		"snapshots.frequency":   "global",
+		"snapshots.keep":        "global",
+		"rate-limit.write.iops": "tenant:foo",
+		"rate-limit.read.iops":  "global",
+		"filesystems.ext4":      "global",
+		"filesystems.btrfs":     "global",
+		"filesystems.xfs":       "tenant:base",
+		"profiles.fast":         "tenant:foo",
+	})
+
+	// the layers apply in order, then the profile and the request.
+	vc, err := s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "bar", Opts: map[string]string{"profile": "fast", "rate-limit.read.iops": "1"}})
+	c.Assert(err, IsNil)
+	defer s.tlc.RemoveVolume("foo", "bar", 0)
+	c.Assert(vc.Options.Pool, Equals, "base")
+	c.Assert(vc.Options.UseSnapshots, Equals, true)
+	c.Assert(vc.Options.RateLimit, DeepEquals, RateLimitConfig{WriteIOPS: 5000, ReadIOPS: 1})
+
+	// cycles are refused.
+	c.Assert(s.tlc.PublishTenant("base", &TenantConfig{Extends: "foo"}), ErrorMatches, `Tenant "base" extends itself through "base"`)
+
+	c.Assert(s.tlc.DeleteGlobal(), IsNil)
+	_, err = s.tlc.GetGlobal()
+	c.Assert(err, Equals, ErrNotExist)
+}
+
+func (s *configSuite) TestResolveTenantZeroValues(c *C) {
+	c.Assert(s.tlc.PublishGlobal(&TenantConfig{
+		DefaultVolumeOptions: VolumeOptions{
+			Pool:         "rbd",
+			Size:         10,
+			UseSnapshots: true,
+			Snapshot:     SnapshotConfig{Frequency: "30m", Keep: 20},
+			RateLimit:    RateLimitConfig{WriteIOPS: 1000},
+		},
+	}), IsNil)
+	defer s.tlc.DeleteGlobal()
+
+	// options set to false or zero explicitly override the layers below.
+	tc := &TenantConfig{}
+	c.Assert(json.Unmarshal([]byte(`{"default-options": {"snapshots": false, "rate-limit": {"write-iops": 0}}}`), tc), IsNil)
+	c.Assert(s.tlc.PublishTenant("foo", tc), IsNil)
+
+	resolved, sources, err := s.tlc.ResolveTenant("foo")
+	c.Assert(err, IsNil)
+	c.Assert(resolved.DefaultVolumeOptions.UseSnapshots, Equals, false)
+	c.Assert(resolved.DefaultVolumeOptions.RateLimit.WriteIOPS, Equals, uint(0))
+	c.Assert(resolved.DefaultVolumeOptions.Pool, Equals, "rbd")
+	c.Assert(sources["snapshots"], Equals, "tenant:foo")
+	c.Assert(sources["rate-limit.write.iops"], Equals, "tenant:foo")
+	c.Assert(sources["pool"], Equals, "global")
+
+	content, err := json.Marshal(resolved)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Matches, `.*"snapshots":false.*`)
+
+	// options left out are inherited.
+	c.Assert(s.tlc.PublishTenant("foo", &TenantConfig{}), IsNil)
+
+	resolved, _, err = s.tlc.ResolveTenant("foo")
+	c.Assert(err, IsNil)
+	c.Assert(resolved.DefaultVolumeOptions.UseSnapshots, Equals, true)
+	c.Assert(resolved.DefaultVolumeOptions.RateLimit.WriteIOPS, Equals, uint(1000))
+}
+
+func (s *configSuite) TestResolveTenantDependents(c *C) {
+	base := &TenantConfig{DefaultVolumeOptions: VolumeOptions{Pool: "rbd", Size: 10}}
+	c.Assert(s.tlc.PublishTenant("base", base), IsNil)
+
+	// the default filesystems are stored, but the caller's configuration is
+	// left alone.
+	c.Assert(base.FileSystems, IsNil)
+	c.Assert(s.getTenant(c, "base").FileSystems, DeepEquals, defaultFilesystems)
+
+	c.Assert(s.tlc.PublishTenant("foo", &TenantConfig{Extends: "base", Quota: &QuotaConfig{MaxVolumeSize: 20}}), IsNil)
+
+	// changes which leave a tenant inheriting them invalid are refused.
+	c.Assert(s.tlc.PublishTenant("base", &TenantConfig{DefaultVolumeOptions: VolumeOptions{Pool: "rbd", Size: 50}}), ErrorMatches, `Tenant "foo" inherits this configuration and would become invalid: Default size 50 MB .*`)
+	c.Assert(s.tlc.PublishTenant("base", &TenantConfig{DefaultVolumeOptions: VolumeOptions{Pool: "rbd", Size: 15}}), IsNil)
+
+	c.Assert(s.tlc.PublishGlobal(&TenantConfig{Quota: &QuotaConfig{MaxVolumeSize: 5}}), ErrorMatches, `Tenant "base" inherits this configuration and would become invalid: .*`)
+	c.Assert(s.tlc.PublishGlobal(&TenantConfig{Quota: &QuotaConfig{MaxVolumeSize: 100}}), IsNil)
+	c.Assert(s.tlc.DeleteGlobal(), IsNil)
+}
+
+func (s *configSuite) TestTenantDeleteExtended(c *C) {
+	c.Assert(s.tlc.PublishTenant("base", &TenantConfig{DefaultVolumeOptions: VolumeOptions{Pool: "rbd", Size: 10}}), IsNil)
+	c.Assert(s.tlc.PublishTenant("mid", &TenantConfig{Extends: "base"}), IsNil)
+	c.Assert(s.tlc.PublishTenant("leaf", &TenantConfig{Extends: "mid"}), IsNil)
+
+	// tenants which extend a tenant, directly or not, would no longer resolve.
+	c.Assert(s.tlc.DeleteTenant("base", 0), ErrorMatches, `Tenant "base" is extended by tenant\(s\) "leaf", "mid"; change or remove them first`)
+	c.Assert(s.tlc.DeleteTenant("mid", 0), ErrorMatches, `Tenant "mid" is extended by tenant\(s\) "leaf"; .*`)
+
+	_, _, err := s.tlc.ResolveTenant("leaf")
+	c.Assert(err, IsNil)
+
+	c.Assert(s.tlc.DeleteTenant("leaf", 0), IsNil)
+	c.Assert(s.tlc.DeleteTenant("mid", 0), IsNil)
+	c.Assert(s.tlc.DeleteTenant("base", 0), IsNil)
+}
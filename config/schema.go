@@ -0,0 +1,295 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// schemaVersionKey is the key each stored record carries its schema version
+// in. Records written before versioning have no key, and are version 0.
+const schemaVersionKey = "schema-version"
+
+// migration upgrades a decoded record by one schema version, in place.
+type migration func(record map[string]interface{}) error
+
+// migrations holds, per kind of record, the migrations which upgrade it. The
+// migration at index N upgrades version N to N+1, so the current version of a
+// kind is the number of migrations registered for it. Kinds are named after
+// the directory the records are stored in.
+//
+// To change the stored form of a record, append a migration for its kind.
+var migrations = map[string][]migration{
+	rootVolume: {stampVersion, addVolumeState},
+	rootTenant: {stampVersion, dropZeroOptions},
+	rootMount:  {stampVersion, addMountTenant},
+	rootHolder: {stampVersion},
+	rootTrim:   {stampVersion},
+	rootGlobal: {stampVersion, dropZeroOptions},
+}
+
+// relocations hold, per kind of record, the function which returns the key an
+// upgraded record belongs at, for kinds whose keys changed, along with the
+// record to write there.
+var relocations = map[string]func(*TopLevelConfig, []byte) (string, []byte, error){
+	rootMount: (*TopLevelConfig).relocateMount,
+}
+
+// stampVersion is the migration from unversioned records, which changes
+// nothing but the version.
+func stampVersion(record map[string]interface{}) error {
+	return nil
+}
+
+func currentVersion(kind string) int {
+	return len(migrations[kind])
+}
+
+func decodeRecord(content []byte) (map[string]interface{}, int, error) {
+	record := map[string]interface{}{}
+
+	// UseNumber keeps large integers such as rate limits intact.
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&record); err != nil {
+		return nil, 0, err
+	}
+
+	version := 0
+	if num, ok := record[schemaVersionKey].(json.Number); ok {
+		v, err := num.Int64()
+		if err != nil {
+			return nil, 0, fmt.Errorf("Invalid schema version %q", num)
+		}
+
+		version = int(v)
+	}
+
+	return record, version, nil
+}
+
+// lookupPath returns the value at the path of nested objects in the record.
+func lookupPath(record map[string]interface{}, path []string) (interface{}, bool) {
+	value, ok := record[path[0]]
+	if !ok || len(path) == 1 {
+		return value, ok
+	}
+
+	sub, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	return lookupPath(sub, path[1:])
+}
+
+// deletePath removes the value at the path of nested objects in the record,
+// along with the objects it leaves empty.
+func deletePath(record map[string]interface{}, path []string) {
+	if len(path) == 1 {
+		delete(record, path[0])
+		return
+	}
+
+	sub, ok := record[path[0]].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	deletePath(sub, path[1:])
+	if len(sub) == 0 {
+		delete(record, path[0])
+	}
+}
+
+// dropZeroOptions is the migration which removes the default options set to
+// their zero value from tenant records. Older releases wrote every option, and
+// zero meant inherited; now a zero set explicitly overrides the layers below.
+func dropZeroOptions(record map[string]interface{}) error {
+	options, ok := record["default-options"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	for _, path := range optionPaths() {
+		value, ok := lookupPath(options, path)
+		if !ok {
+			continue
+		}
+
+		switch value := value.(type) {
+		case nil:
+		case string:
+			if value != "" {
+				continue
+			}
+		case bool:
+			if value {
+				continue
+			}
+		case json.Number:
+			if f, err := value.Float64(); err != nil || f != 0 {
+				continue
+			}
+		default:
+			continue
+		}
+
+		deletePath(options, path)
+	}
+
+	return nil
+}
+
+// upgradeRecord applies the pending migrations for the kind to the record,
+// returning the upgraded content and the version it was upgraded from.
+func upgradeRecord(kind string, content []byte) ([]byte, int, error) {
+	record, version, err := decodeRecord(content)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	current := currentVersion(kind)
+
+	if version > current {
+		return nil, version, fmt.Errorf("Record has schema version %d, newer than the supported version %d", version, current)
+	}
+
+	if version == current {
+		return content, version, nil
+	}
+
+	for _, migrate := range migrations[kind][version:] {
+		if err := migrate(record); err != nil {
+			return nil, version, err
+		}
+	}
+
+	record[schemaVersionKey] = current
+
+	upgraded, err := json.Marshal(record)
+	return upgraded, version, err
+}
+
+// marshalRecord marshals v and stamps it with the current schema version of
+// the kind.
+func marshalRecord(kind string, v interface{}) ([]byte, error) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	record, _, err := decodeRecord(content)
+	if err != nil {
+		return nil, err
+	}
+
+	record[schemaVersionKey] = currentVersion(kind)
+
+	return json.Marshal(record)
+}
+
+// unmarshalRecord upgrades the stored record of the kind to the current
+// schema version and unmarshals it into v.
+func unmarshalRecord(kind string, content []byte, v interface{}) error {
+	upgraded, _, err := upgradeRecord(kind, content)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(upgraded, v)
+}
+
+// Migration describes a record whose stored schema is older than the current
+// one. NewKey is set if the record moves to another key.
+type Migration struct {
+	Key         string
+	NewKey      string
+	FromVersion int
+	ToVersion   int
+}
+
+// MigrateRecords finds every record under the prefix whose schema is out of
+// date. Unless dryRun is set, each is upgraded and written back, provided it
+// has not changed in the meantime. The migrations found are returned.
+func (c *TopLevelConfig) MigrateRecords(dryRun bool) ([]Migration, error) {
+	pending := []Migration{}
+
+	kinds := []string{}
+	for kind := range migrations {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	for _, kind := range kinds {
+		entries, err := c.store.List(c.prefixed(kind))
+		if err != nil {
+			return pending, err
+		}
+
+		for _, entry := range entries {
+			upgraded, version, err := upgradeRecord(kind, []byte(entry.Value))
+			if err != nil {
+				return pending, fmt.Errorf("Migrating %q: %v", entry.Key, err)
+			}
+
+			if version == currentVersion(kind) {
+				continue
+			}
+
+			key := entry.Key
+			if relocate, ok := relocations[kind]; ok {
+				key, upgraded, err = relocate(c, upgraded)
+				if err != nil {
+					return pending, fmt.Errorf("Migrating %q: %v", entry.Key, err)
+				}
+			}
+
+			if !dryRun {
+				if err := c.writeMigrated(entry, key, upgraded); err != nil {
+					return pending, fmt.Errorf("Migrating %q: %v", entry.Key, err)
+				}
+			}
+
+			migration := Migration{Key: entry.Key, FromVersion: version, ToVersion: currentVersion(kind)}
+			if key != entry.Key {
+				migration.NewKey = key
+			}
+
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// writeMigrated writes the upgraded record to key, provided the stored record
+// has not changed in the meantime. Records moving to another key are only
+// written there if the key is free. Records which expire, such as mounts,
+// keep what is left of their TTL.
+func (c *TopLevelConfig) writeMigrated(entry *Entry, key string, upgraded []byte) error {
+	if key == entry.Key {
+		_, err := c.store.Set(entry.Key, string(upgraded), SetOptions{PrevExist: PrevExist, PrevValue: entry.Value, TTL: entry.TTL})
+		return err
+	}
+
+	if _, err := c.store.Set(key, string(upgraded), SetOptions{PrevExist: PrevNoExist, TTL: entry.TTL}); err != nil {
+		return err
+	}
+
+	if err := c.store.Delete(entry.Key, DeleteOptions{PrevValue: entry.Value}); err != nil {
+		c.store.Delete(key, DeleteOptions{PrevValue: string(upgraded)})
+		return err
+	}
+
+	return nil
+}
+
+func (m Migration) String() string {
+	if m.NewKey != "" {
+		return fmt.Sprintf("%s: schema version %d -> %d, moved to %s", m.Key, m.FromVersion, m.ToVersion, m.NewKey)
+	}
+
+	return fmt.Sprintf("%s: schema version %d -> %d", m.Key, m.FromVersion, m.ToVersion)
+}
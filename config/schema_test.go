@@ -0,0 +1,79 @@
+package config
+
+import (
+	"encoding/json"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *configSuite) TestSchemaStamp(c *C) {
+	content, err := marshalRecord(rootTenant, testTenantConfigs["basic"])
+	c.Assert(err, IsNil)
+
+	record := map[string]interface{}{}
+	c.Assert(json.Unmarshal(content, &record), IsNil)
+	c.Assert(record[schemaVersionKey], Equals, float64(currentVersion(rootTenant)))
+
+	tc := &TenantConfig{}
+	c.Assert(unmarshalRecord(rootTenant, content, tc), IsNil)
+	c.Assert(tc, DeepEquals, testTenantConfigs["basic"])
+
+	// unversioned records predate versioning and still decode. They were
+	// written with every option, where zero meant inherited.
+	content, err = json.Marshal(tenantConfigJSON(*testTenantConfigs["basic"]))
+	c.Assert(err, IsNil)
+	tc = &TenantConfig{}
+	c.Assert(unmarshalRecord(rootTenant, content, tc), IsNil)
+	c.Assert(tc, DeepEquals, testTenantConfigs["basic"])
+
+	c.Assert(unmarshalRecord(rootTenant, []byte(`{"schema-version": 1000}`), tc), NotNil)
+}
+
+func (s *configSuite) TestSchemaMigration(c *C) {
+	// simulate a release which renamed the size field.
+	oldMigrations := migrations[rootVolume]
+	defer func() { migrations[rootVolume] = oldMigrations }()
+
+	migrations[rootVolume] = append(oldMigrations, func(record map[string]interface{}) error {
+		if opts, ok := record["options"].(map[string]interface{}); ok {
+			opts["size"] = opts["old-size"]
+			delete(opts, "old-size")
+		}
+
+		return nil
+	})
+
+	old := `{"tenant":"foo","name":"bar","options":{"pool":"rbd","old-size":10,"rate-limit":{"write-bps":9007199254740993}}}`
+	_, err := s.tlc.store.Set(s.tlc.volume("foo", "bar"), old, SetOptions{})
+	c.Assert(err, IsNil)
+
+	vc, err := s.tlc.GetVolume("foo", "bar")
+	c.Assert(err, IsNil)
+	c.Assert(vc.Options.Size, Equals, uint64(10))
+	c.Assert(vc.Options.RateLimit.WriteBPS, Equals, uint64(9007199254740993))
+
+	pending, err := s.tlc.MigrateRecords(true)
+	c.Assert(err, IsNil)
+	c.Assert(pending, DeepEquals, []Migration{{Key: s.tlc.volume("foo", "bar"), FromVersion: 0, ToVersion: 3}})
+
+	entry, err := s.tlc.store.Get(s.tlc.volume("foo", "bar"))
+	c.Assert(err, IsNil)
+	c.Assert(entry.Value, Equals, old)
+
+	pending, err = s.tlc.MigrateRecords(false)
+	c.Assert(err, IsNil)
+	c.Assert(pending, HasLen, 1)
+
+	pending, err = s.tlc.MigrateRecords(true)
+	c.Assert(err, IsNil)
+	c.Assert(pending, HasLen, 0)
+
+	entry, err = s.tlc.store.Get(s.tlc.volume("foo", "bar"))
+	c.Assert(err, IsNil)
+
+	record, version, err := decodeRecord([]byte(entry.Value))
+	c.Assert(err, IsNil)
+	c.Assert(version, Equals, 3)
+	c.Assert(record["state"], Equals, string(StateReady))
+	c.Assert(record["options"].(map[string]interface{})["old-size"], IsNil)
+}
@@ -0,0 +1,157 @@
+package config
+
+import "fmt"
+
+// VolumeState is the lifecycle state of a volume.
+type VolumeState string
+
+const (
+	// StateCreating is the state of a volume whose image is being created.
+	StateCreating VolumeState = "creating"
+	// StateReady is the state of a volume which may be mounted or removed.
+	StateReady VolumeState = "ready"
+	// StateMounted is the state of a volume which is mounted on some host.
+	StateMounted VolumeState = "mounted"
+	// StateRemoving is the state of a volume whose image is being removed.
+	StateRemoving VolumeState = "removing"
+	// StateMigrating is the state of a volume whose image is being copied to
+	// another pool.
+	StateMigrating VolumeState = "migrating"
+	// StateFailed is the state of a volume whose image could not be created
+	// or removed. The error is recorded with the volume.
+	StateFailed VolumeState = "failed"
+)
+
+// maxTransitionRetries bounds how often a transition is retried when the
+// volume changes underneath it.
+const maxTransitionRetries = 10
+
+// volumeTransitions holds the states each state may move to. Creating volumes
+// may be removed, since their create may have been cut short by a volmaster
+// which stopped; a create which is still running undoes itself once it finds
+// the volume gone.
+var volumeTransitions = map[VolumeState][]VolumeState{
+	StateCreating:  {StateReady, StateFailed, StateRemoving},
+	StateReady:     {StateMounted, StateRemoving, StateMigrating},
+	StateMounted:   {StateMounted, StateReady, StateRemoving, StateMigrating},
+	StateRemoving:  {StateFailed},
+	StateFailed:    {StateRemoving},
+	StateMigrating: {StateReady, StateRemoving},
+}
+
+// addVolumeState is the migration to records with a state. Volumes recorded
+// before states existed are ready; the ones mounted at the time are still
+// protected from removal by their mount records.
+func addVolumeState(record map[string]interface{}) error {
+	if _, ok := record["state"]; !ok {
+		record["state"] = string(StateReady)
+	}
+
+	return nil
+}
+
+// CheckMountable returns an error unless the volume may be mounted.
+func (cfg *VolumeConfig) CheckMountable() error {
+	switch cfg.State {
+	case StateReady, StateMounted:
+		return nil
+	case StateFailed:
+		return fmt.Errorf("Volume %q of tenant %q is %s: %s", cfg.VolumeName, cfg.TenantName, cfg.State, cfg.StateError)
+	default:
+		return fmt.Errorf("Volume %q of tenant %q is %s, not ready", cfg.VolumeName, cfg.TenantName, cfg.State)
+	}
+}
+
+// TransitionVolume moves the volume to the state. The volume is written with
+// a compare-and-swap, so that of two concurrent transitions only one is
+// made from the state both saw; the other is checked again against the state
+// it lost to. msg is the error recorded with StateFailed. Volumes only move to
+// StateRemoving or StateMigrating while no host holds them, whatever their
+// state says: mounted volumes once their mounts expired, and volumes migrated
+// to StateReady while mounted once they are unmounted. The updated volume is
+// returned.
+func (c *TopLevelConfig) TransitionVolume(tenant, name string, to VolumeState, msg string) (*VolumeConfig, error) {
+	return c.TransitionVolumeAt(tenant, name, 0, to, msg)
+}
+
+// TransitionVolumeAt is TransitionVolume for a volume expected to be at
+// revision. It fails with ErrConflict if the volume was written since, up to
+// the compare-and-swap which makes the transition. A revision of 0 accepts
+// any revision.
+func (c *TopLevelConfig) TransitionVolumeAt(tenant, name string, revision uint64, to VolumeState, msg string) (*VolumeConfig, error) {
+	for i := 0; ; i++ {
+		vc, err := c.transitionVolume(tenant, name, revision, to, msg)
+		if err != ErrCompareFailed || i == maxTransitionRetries {
+			return vc, conflict(err)
+		}
+	}
+}
+
+func (c *TopLevelConfig) transitionVolume(tenant, name string, revision uint64, to VolumeState, msg string) (*VolumeConfig, error) {
+	entry, err := c.store.Get(c.volume(tenant, name))
+	if err != nil {
+		return nil, err
+	}
+
+	if revision != 0 && revision != entry.Revision {
+		return nil, ErrConflict
+	}
+
+	vc := &VolumeConfig{}
+	if err := unmarshalRecord(rootVolume, []byte(entry.Value), vc); err != nil {
+		return nil, err
+	}
+
+	vc.Revision = entry.Revision
+
+	// mounted volumes are rewritten, so that the revision guards against a
+	// concurrent removal.
+	if vc.State == to && to != StateMounted && to != StateFailed {
+		return vc, nil
+	}
+
+	if !canTransition(vc.State, to) {
+		return nil, fmt.Errorf("Volume %q of tenant %q is %s and cannot become %s", name, tenant, vc.State, to)
+	}
+
+	if to == StateRemoving || to == StateMigrating {
+		holders, err := c.MountHolders(tenant, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(holders) > 0 {
+			return nil, fmt.Errorf("Volume %q of tenant %q is mounted on host %q", name, tenant, holders[0].Host)
+		}
+	}
+
+	vc.State = to
+	vc.StateError = ""
+	if to == StateFailed {
+		vc.StateError = msg
+	}
+
+	remarshal, err := marshalRecord(rootVolume, vc)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err = c.store.Set(entry.Key, string(remarshal), SetOptions{PrevExist: PrevExist, PrevRevision: entry.Revision})
+	if err != nil {
+		return nil, err
+	}
+
+	vc.Revision = entry.Revision
+
+	return vc, nil
+}
+
+func canTransition(from, to VolumeState) bool {
+	for _, state := range volumeTransitions[from] {
+		if state == to {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *configSuite) TestVolumeState(c *C) {
+	c.Assert(s.tlc.PublishTenant("foo", testTenantConfigs["basic"]), IsNil)
+
+	vc, err := s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "quux"})
+	c.Assert(err, IsNil)
+	c.Assert(vc.State, Equals, StateCreating)
+	c.Assert(vc.CheckMountable(), ErrorMatches, `Volume "quux" of tenant "foo" is creating, not ready`)
+
+	mt := testMountConfigs["basic"]
+	c.Assert(s.tlc.PublishMount(mt), ErrorMatches, `Volume "quux" of tenant "foo" is creating and cannot become mounted`)
+	_, err = s.tlc.GetMount("foo", "quux")
+	c.Assert(err, Equals, ErrNotExist)
+
+	vc, err = s.tlc.TransitionVolume("foo", "quux", StateReady, "")
+	c.Assert(err, IsNil)
+	c.Assert(vc.State, Equals, StateReady)
+	c.Assert(vc.CheckMountable(), IsNil)
+
+	c.Assert(s.tlc.PublishMount(mt), IsNil)
+	vc, err = s.tlc.GetVolume("foo", "quux")
+	c.Assert(err, IsNil)
+	c.Assert(vc.State, Equals, StateMounted)
+
+	_, err = s.tlc.TransitionVolume("foo", "quux", StateRemoving, "")
+	c.Assert(err, ErrorMatches, `Volume "quux" of tenant "foo" is mounted on host "hostname"`)
+
+	c.Assert(s.tlc.RemoveMount(mt, false), IsNil)
+	vc, err = s.tlc.GetVolume("foo", "quux")
+	c.Assert(err, IsNil)
+	c.Assert(vc.State, Equals, StateReady)
+
+	_, err = s.tlc.TransitionVolume("foo", "quux", StateRemoving, "")
+	c.Assert(err, IsNil)
+	c.Assert(s.tlc.PublishMount(mt), NotNil)
+
+	vc, err = s.tlc.TransitionVolume("foo", "quux", StateFailed, "rbd rm failed")
+	c.Assert(err, IsNil)
+	c.Assert(vc.StateError, Equals, "rbd rm failed")
+	c.Assert(vc.CheckMountable(), ErrorMatches, `Volume "quux" of tenant "foo" is failed: rbd rm failed`)
+
+	vc, err = s.tlc.TransitionVolume("foo", "quux", StateRemoving, "")
+	c.Assert(err, IsNil)
+	c.Assert(vc.StateError, Equals, "")
+
+	_, err = s.tlc.TransitionVolume("foo", "nonexistent", StateReady, "")
+	c.Assert(err, Equals, ErrNotExist)
+}
+
+func (s *configSuite) TestVolumeStateExpiredMount(c *C) {
+	s.readyVolume(c, "foo", "quux")
+
+	mt := *testMountConfigs["basic"]
+	mt.TTL = 100 * time.Millisecond
+	c.Assert(s.tlc.PublishMount(&mt), IsNil)
+
+	// the volume stays mounted when the mount expires, but may be removed.
+	time.Sleep(200 * time.Millisecond)
+	vc, err := s.tlc.GetVolume("foo", "quux")
+	c.Assert(err, IsNil)
+	c.Assert(vc.State, Equals, StateMounted)
+
+	_, err = s.tlc.TransitionVolume("foo", "quux", StateRemoving, "")
+	c.Assert(err, IsNil)
+}
+
+func (s *configSuite) TestVolumeStateRemoveHeld(c *C) {
+	s.readyVolume(c, "foo", "quux")
+	c.Assert(s.tlc.PublishMount(testMountConfigs["basic"]), IsNil)
+
+	// volumes mounted when they were migrated to states are ready, but still
+	// held.
+	vc, err := s.tlc.GetVolume("foo", "quux")
+	c.Assert(err, IsNil)
+	vc.State = StateReady
+	content, err := marshalRecord(rootVolume, vc)
+	c.Assert(err, IsNil)
+	_, err = s.tlc.store.Set(s.tlc.volume("foo", "quux"), string(content), SetOptions{})
+	c.Assert(err, IsNil)
+
+	_, err = s.tlc.TransitionVolume("foo", "quux", StateRemoving, "")
+	c.Assert(err, ErrorMatches, `Volume "quux" of tenant "foo" is mounted on host "hostname"`)
+
+	// a create which was cut short can be removed.
+	_, err = s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "bar"})
+	c.Assert(err, IsNil)
+	vc, err = s.tlc.TransitionVolume("foo", "bar", StateRemoving, "")
+	c.Assert(err, IsNil)
+	c.Assert(vc.State, Equals, StateRemoving)
+}
+
+func (s *configSuite) TestVolumeStateRevision(c *C) {
+	s.readyVolume(c, "foo", "quux")
+
+	vc, err := s.tlc.GetVolume("foo", "quux")
+	c.Assert(err, IsNil)
+
+	_, err = s.tlc.UpdateVolume(RequestUpdate{Tenant: "foo", Volume: "quux", Opts: map[string]string{"ephemeral": "true"}})
+	c.Assert(err, IsNil)
+
+	_, err = s.tlc.TransitionVolumeAt("foo", "quux", vc.Revision, StateRemoving, "")
+	c.Assert(err, Equals, ErrConflict)
+
+	vc, err = s.tlc.GetVolume("foo", "quux")
+	c.Assert(err, IsNil)
+	c.Assert(vc.State, Equals, StateReady)
+
+	vc, err = s.tlc.TransitionVolumeAt("foo", "quux", vc.Revision, StateRemoving, "")
+	c.Assert(err, IsNil)
+	c.Assert(vc.State, Equals, StateRemoving)
+}
+
+func (s *configSuite) TestVolumeStateMigrating(c *C) {
+	s.readyVolume(c, "foo", "quux")
+
+	mt := testMountConfigs["basic"]
+	c.Assert(s.tlc.PublishMount(mt), IsNil)
+
+	_, err := s.tlc.TransitionVolume("foo", "quux", StateMigrating, "")
+	c.Assert(err, ErrorMatches, `Volume "quux" of tenant "foo" is mounted on host "hostname"`)
+
+	c.Assert(s.tlc.RemoveMount(mt, false), IsNil)
+
+	// migrating volumes cannot be mounted until the migration is over.
+	vc, err := s.tlc.TransitionVolume("foo", "quux", StateMigrating, "")
+	c.Assert(err, IsNil)
+	c.Assert(vc.CheckMountable(), ErrorMatches, `Volume "quux" of tenant "foo" is migrating, not ready`)
+	c.Assert(s.tlc.PublishMount(mt), ErrorMatches, `Volume "quux" of tenant "foo" is migrating and cannot become mounted`)
+
+	vc, err = s.tlc.TransitionVolume("foo", "quux", StateReady, "")
+	c.Assert(err, IsNil)
+	c.Assert(vc.CheckMountable(), IsNil)
+}
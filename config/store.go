@@ -0,0 +1,117 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotExist indicates that a key does not exist in the store.
+var ErrNotExist = errors.New("Does not exist")
+
+// ErrCompareFailed indicates that the value of a key did not match the value
+// a conditional set or delete expected.
+var ErrCompareFailed = errors.New("Compare failed")
+
+// PrevExistType is the condition on the existence of a key for a set
+// operation.
+type PrevExistType int
+
+const (
+	// PrevIgnore sets the key whether it exists or not.
+	PrevIgnore PrevExistType = iota
+	// PrevExist requires the key to exist already.
+	PrevExist
+	// PrevNoExist requires the key to not exist yet.
+	PrevNoExist
+)
+
+// SetOptions are the conditions on a Store.Set call.
+type SetOptions struct {
+	PrevExist    PrevExistType
+	PrevValue    string        // if non-empty, the current value must match
+	PrevRevision uint64        // if non-zero, the current revision must match
+	TTL          time.Duration // if non-zero, the key expires after this duration
+}
+
+// DeleteOptions are the conditions on a Store.Delete call.
+type DeleteOptions struct {
+	PrevValue    string // if non-empty, the current value must match
+	PrevRevision uint64 // if non-zero, the current revision must match
+}
+
+// Entry is a key and its value as held by a Store. Revision changes every
+// time the key is modified. TTL is the time left until the key expires, or
+// zero if it does not expire.
+type Entry struct {
+	Key      string
+	Value    string
+	Revision uint64
+	TTL      time.Duration
+}
+
+// WatchEvent is a change to a key delivered by Store.Watch. Entry.Value is
+// empty for deletions.
+type WatchEvent struct {
+	Deleted bool
+	Entry   *Entry
+}
+
+// Store is the key/value store which holds the intent. Keys are slash
+// separated paths. Implementations map errors to ErrExist, ErrNotExist and
+// ErrCompareFailed where applicable.
+type Store interface {
+	// Get returns the entry for a key.
+	Get(key string) (*Entry, error)
+	// Set stores the value for a key, subject to opts.
+	Set(key, value string, opts SetOptions) (*Entry, error)
+	// Delete removes a key, subject to opts.
+	Delete(key string, opts DeleteOptions) error
+	// List returns every entry below the directory dir, at any depth, sorted
+	// by key. A directory with no entries yields an empty list.
+	List(dir string) ([]*Entry, error)
+	// Watch delivers the changes to keys below the directory dir until stop is
+	// closed.
+	Watch(dir string, stop <-chan struct{}) (<-chan *WatchEvent, error)
+}
+
+// NewStore returns a Store talking to the etcd hosts with the named etcd API
+// version, "v2" or "v3".
+func NewStore(api string, etcdHosts []string) (Store, error) {
+	switch api {
+	case "", "v2":
+		return NewEtcdStore(etcdHosts)
+	case "v3":
+		return NewEtcd3Store(etcdHosts)
+	}
+
+	return nil, fmt.Errorf("Invalid etcd API version %q: must be v2 or v3", api)
+}
+
+// CopyTree copies every entry below the directory dir from one store to
+// another. Entries which exist in the destination already are left alone;
+// the keys copied and skipped are returned. Copies expire when the entries
+// they were made from would have.
+func CopyTree(dir string, from, to Store) ([]string, []string, error) {
+	entries, err := from.List(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	copied := []string{}
+	skipped := []string{}
+
+	for _, entry := range entries {
+		_, err := to.Set(entry.Key, entry.Value, SetOptions{PrevExist: PrevNoExist, TTL: entry.TTL})
+		switch err {
+		case nil:
+			copied = append(copied, entry.Key)
+		case ErrExist:
+			skipped = append(skipped, entry.Key)
+		default:
+			return copied, skipped, err
+		}
+	}
+
+	return copied, skipped, nil
+}
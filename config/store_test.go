@@ -0,0 +1,211 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type storeSuite struct {
+	newStore func(c *C) Store
+}
+
+var _ = Suite(&storeSuite{newStore: func(c *C) Store { return NewMemoryStore() }})
+
+// the etcd store is only exercised on hosts which run etcd.
+var _ = Suite(&storeSuite{newStore: func(c *C) Store {
+	if os.Getenv("HOST_TEST") == "" {
+		c.Skip("HOST_TEST is not set; etcd is not available")
+	}
+
+	exec.Command("/bin/sh", "-c", "sudo systemctl start etcd").Run()
+	exec.Command("/bin/sh", "-c", "etcdctl rm --recursive /storetest").Run()
+
+	store, err := NewEtcdStore([]string{"http://127.0.0.1:2379"})
+	c.Assert(err, IsNil)
+
+	return store
+}})
+
+// the etcd v3 store needs an etcd release which serves the v3 JSON gateway.
+var _ = Suite(&storeSuite{newStore: func(c *C) Store {
+	if os.Getenv("ETCD3_TEST") == "" {
+		c.Skip("ETCD3_TEST is not set; etcd v3 is not available")
+	}
+
+	exec.Command("/bin/sh", "-c", "ETCDCTL_API=3 etcdctl del --prefix /storetest").Run()
+
+	store, err := NewEtcd3Store([]string{"http://127.0.0.1:2379"})
+	c.Assert(err, IsNil)
+
+	return store
+}})
+
+func (s *storeSuite) TestStoreSetGetDelete(c *C) {
+	store := s.newStore(c)
+
+	_, err := store.Get("/storetest/foo")
+	c.Assert(err, Equals, ErrNotExist)
+
+	entry, err := store.Set("/storetest/foo", "bar", SetOptions{PrevExist: PrevNoExist})
+	c.Assert(err, IsNil)
+	c.Assert(entry.Key, Equals, "/storetest/foo")
+	c.Assert(entry.Value, Equals, "bar")
+
+	_, err = store.Set("/storetest/foo", "baz", SetOptions{PrevExist: PrevNoExist})
+	c.Assert(err, Equals, ErrExist)
+
+	_, err = store.Set("/storetest/quux", "baz", SetOptions{PrevExist: PrevExist})
+	c.Assert(err, Equals, ErrNotExist)
+
+	_, err = store.Set("/storetest/foo", "baz", SetOptions{PrevValue: "quux"})
+	c.Assert(err, Equals, ErrCompareFailed)
+
+	entry2, err := store.Set("/storetest/foo", "baz", SetOptions{PrevExist: PrevExist, PrevValue: "bar"})
+	c.Assert(err, IsNil)
+	c.Assert(entry2.Revision > entry.Revision, Equals, true)
+
+	entry, err = store.Get("/storetest/foo")
+	c.Assert(err, IsNil)
+	c.Assert(entry.Value, Equals, "baz")
+	c.Assert(entry.Revision, Equals, entry2.Revision)
+
+	_, err = store.Set("/storetest/foo", "quux", SetOptions{PrevRevision: entry.Revision + 1000})
+	c.Assert(err, Equals, ErrCompareFailed)
+
+	entry2, err = store.Set("/storetest/foo", "quux", SetOptions{PrevRevision: entry.Revision})
+	c.Assert(err, IsNil)
+
+	_, err = store.Set("/storetest/foo", "baz", SetOptions{PrevRevision: entry.Revision})
+	c.Assert(err, Equals, ErrCompareFailed)
+
+	entry, err = store.Set("/storetest/foo", "baz", SetOptions{PrevRevision: entry2.Revision})
+	c.Assert(err, IsNil)
+
+	c.Assert(store.Delete("/storetest/foo", DeleteOptions{PrevRevision: entry2.Revision}), Equals, ErrCompareFailed)
+	c.Assert(store.Delete("/storetest/foo", DeleteOptions{PrevRevision: entry.Revision, PrevValue: "bar"}), Equals, ErrCompareFailed)
+	c.Assert(store.Delete("/storetest/foo", DeleteOptions{PrevValue: "baz"}), IsNil)
+	c.Assert(store.Delete("/storetest/foo", DeleteOptions{}), Equals, ErrNotExist)
+}
+
+func (s *storeSuite) TestStoreList(c *C) {
+	store := s.newStore(c)
+
+	entries, err := store.List("/storetest")
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+
+	for _, key := range []string{"/storetest/b/c", "/storetest/a", "/storetest/b/a/d", "/storetestx"} {
+		_, err := store.Set(key, key, SetOptions{})
+		c.Assert(err, IsNil)
+	}
+
+	entries, err = store.List("/storetest")
+	c.Assert(err, IsNil)
+
+	keys := []string{}
+	for _, entry := range entries {
+		keys = append(keys, entry.Key)
+		c.Assert(entry.Value, Equals, entry.Key)
+	}
+
+	c.Assert(keys, DeepEquals, []string{"/storetest/a", "/storetest/b/a/d", "/storetest/b/c"})
+	c.Assert(store.Delete("/storetestx", DeleteOptions{}), IsNil)
+}
+
+func (s *storeSuite) TestStoreWatch(c *C) {
+	store := s.newStore(c)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	events, err := store.Watch("/storetest", stop)
+	c.Assert(err, IsNil)
+
+	_, err = store.Set("/storetest/foo", "bar", SetOptions{})
+	c.Assert(err, IsNil)
+	_, err = store.Set("/storetestx", "bar", SetOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(store.Delete("/storetestx", DeleteOptions{}), IsNil)
+	c.Assert(store.Delete("/storetest/foo", DeleteOptions{}), IsNil)
+
+	for _, deleted := range []bool{false, true} {
+		select {
+		case event := <-events:
+			c.Assert(event.Entry.Key, Equals, "/storetest/foo")
+			c.Assert(event.Deleted, Equals, deleted)
+		case <-time.After(5 * time.Second):
+			c.Fatal("timed out waiting for watch event")
+		}
+	}
+}
+
+func (s *storeSuite) TestStoreTTL(c *C) {
+	store := s.newStore(c)
+
+	_, err := store.Set("/storetest/ttl", "bar", SetOptions{TTL: time.Second})
+	c.Assert(err, IsNil)
+
+	entry, err := store.Get("/storetest/ttl")
+	c.Assert(err, IsNil)
+	c.Assert(entry.TTL > 0 && entry.TTL <= time.Second, Equals, true)
+
+	time.Sleep(2500 * time.Millisecond)
+
+	_, err = store.Get("/storetest/ttl")
+	c.Assert(err, Equals, ErrNotExist)
+}
+
+func (s *storeSuite) TestCopyTree(c *C) {
+	from := s.newStore(c)
+	to := NewMemoryStore()
+
+	for _, key := range []string{"/storetest/a", "/storetest/b/c", "/storetest/d"} {
+		_, err := from.Set(key, key, SetOptions{})
+		c.Assert(err, IsNil)
+	}
+
+	_, err := from.Set("/storetest/ttl", "bar", SetOptions{TTL: time.Minute})
+	c.Assert(err, IsNil)
+
+	_, err = to.Set("/storetest/d", "existing", SetOptions{})
+	c.Assert(err, IsNil)
+
+	copied, skipped, err := CopyTree("/storetest", from, to)
+	c.Assert(err, IsNil)
+	c.Assert(copied, DeepEquals, []string{"/storetest/a", "/storetest/b/c", "/storetest/ttl"})
+	c.Assert(skipped, DeepEquals, []string{"/storetest/d"})
+
+	entry, err := to.Get("/storetest/b/c")
+	c.Assert(err, IsNil)
+	c.Assert(entry.Value, Equals, "/storetest/b/c")
+	c.Assert(entry.TTL, Equals, time.Duration(0))
+
+	// keys which expire keep expiring after the copy.
+	entry, err = to.Get("/storetest/ttl")
+	c.Assert(err, IsNil)
+	c.Assert(entry.TTL > 0 && entry.TTL <= time.Minute, Equals, true)
+
+	entry, err = to.Get("/storetest/d")
+	c.Assert(err, IsNil)
+	c.Assert(entry.Value, Equals, "existing")
+}
+
+func (s *configSuite) TestEtcd3Compare(c *C) {
+	// the gateway refuses compares which set more than one target.
+	for target, compare := range map[string]etcd3Compare{
+		`"create_revision":"0"`: compareExists("/foo", false),
+		`"value":"YmFy"`:        compareValue("/foo", "bar"),
+		`"mod_revision":"5"`:    compareRevision("/foo", 5),
+	} {
+		content, err := json.Marshal(compare)
+		c.Assert(err, IsNil)
+
+		out := string(content)
+		c.Assert(strings.Contains(out, target), Equals, true, Commentf(out))
+		c.Assert(strings.Count(out, "revision")+strings.Count(out, `"value"`), Equals, 1, Commentf(out))
+	}
+}
@@ -3,11 +3,7 @@ package config
 import (
 	"encoding/json"
 	"fmt"
-	"path"
-
-	"github.com/coreos/etcd/client"
-
-	"golang.org/x/net/context"
+	"sort"
 )
 
 // TenantConfig is the configuration of the tenant. It includes default
@@ -15,6 +11,130 @@ import (
 type TenantConfig struct {
 	DefaultVolumeOptions VolumeOptions     `json:"default-options"`
 	FileSystems          map[string]string `json:"filesystems"`
+	Quota                *QuotaConfig      `json:"quota,omitempty"`
+
+	// Extends names a tenant this tenant inherits its configuration from.
+	// See ResolveTenant.
+	Extends string `json:"extends,omitempty"`
+
+	// Policy restricts the options users may override, keyed by the option
+	// key used with `docker volume create --opt`.
+	Policy map[string]*OptionPolicy `json:"policy,omitempty"`
+
+	// Profiles are named sets of options which overlay the default options
+	// when selected with the `profile` option.
+	Profiles map[string]map[string]string `json:"profiles,omitempty"`
+
+	// Revision is the revision of the record the tenant was read from. It is
+	// not part of the record. PublishTenant expects the record to be at this
+	// revision still, unless it is zero.
+	Revision uint64 `json:"-"`
+
+	// zeroOptions are the keys of the default options which are set to their
+	// zero value explicitly, such as `"snapshots": false`. The other default
+	// options are set if they are not zero. See ResolveTenant.
+	zeroOptions []string
+}
+
+// tenantConfigJSON is a TenantConfig without its JSON methods.
+type tenantConfigJSON TenantConfig
+
+// UnmarshalJSON decodes a tenant, noting the default options it sets to their
+// zero value.
+func (cfg *TenantConfig) UnmarshalJSON(content []byte) error {
+	if err := json.Unmarshal(content, (*tenantConfigJSON)(cfg)); err != nil {
+		return err
+	}
+
+	raw := struct {
+		Options map[string]interface{} `json:"default-options"`
+	}{}
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return err
+	}
+
+	for key, policy := range cfg.Policy {
+		if policy == nil {
+			continue
+		}
+
+		if err := policy.parseBounds(key); err != nil {
+			return err
+		}
+	}
+
+	cfg.zeroOptions = nil
+
+	for key, path := range optionPaths() {
+		if _, ok := lookupPath(raw.Options, path); ok && cfg.DefaultVolumeOptions.isZero(key) {
+			cfg.zeroOptions = append(cfg.zeroOptions, key)
+		}
+	}
+
+	sort.Strings(cfg.zeroOptions)
+
+	return nil
+}
+
+// MarshalJSON encodes a tenant with only the default options it sets, so that
+// the others are inherited when it is read back.
+func (cfg TenantConfig) MarshalJSON() ([]byte, error) {
+	content, err := json.Marshal(tenantConfigJSON(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	record, _, err := decodeRecord(content)
+	if err != nil {
+		return nil, err
+	}
+
+	options, _ := record["default-options"].(map[string]interface{})
+
+	for key, path := range optionPaths() {
+		if !cfg.setsOption(key) {
+			deletePath(options, path)
+		}
+	}
+
+	return json.Marshal(record)
+}
+
+// setsOption returns whether the default option with the merge tag key is set
+// by the tenant, as opposed to inherited.
+func (cfg *TenantConfig) setsOption(key string) bool {
+	if !cfg.DefaultVolumeOptions.isZero(key) {
+		return true
+	}
+
+	for _, zero := range cfg.zeroOptions {
+		if zero == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setOption records whether the default option with the merge tag key is set
+// to its zero value.
+func (cfg *TenantConfig) setOption(key string) {
+	zeroOptions := []string{}
+	for _, zero := range cfg.zeroOptions {
+		if zero != key {
+			zeroOptions = append(zeroOptions, zero)
+		}
+	}
+
+	if cfg.DefaultVolumeOptions.isZero(key) {
+		zeroOptions = append(zeroOptions, key)
+		sort.Strings(zeroOptions)
+	}
+
+	cfg.zeroOptions = nil
+	if len(zeroOptions) > 0 {
+		cfg.zeroOptions = zeroOptions
+	}
 }
 
 var defaultFilesystems = map[string]string{
@@ -27,61 +147,236 @@ func (c *TopLevelConfig) tenant(name string) string {
 	return c.prefixed(rootTenant, name)
 }
 
-// PublishTenant publishes tenant intent to the configuration store.
+// PublishTenant publishes tenant intent to the configuration store. The
+// tenant is validated as resolved with the configuration it inherits, as are
+// the tenants which inherit from it. If the tenant carries a revision, the
+// write fails with ErrConflict unless the stored tenant is still at it;
+// otherwise the tenant is created or replaced.
 func (c *TopLevelConfig) PublishTenant(name string, cfg *TenantConfig) error {
-	if err := cfg.Validate(); err != nil {
+	if err := ValidateName("tenant", name); err != nil {
 		return err
 	}
 
-	value, err := json.Marshal(cfg)
+	resolved, _, err := c.resolveTenant(name, cfg, nil)
 	if err != nil {
 		return err
 	}
 
-	c.etcdClient.Set(context.Background(), c.prefixed(rootVolume, name), "", &client.SetOptions{Dir: true})
+	// tenants which inherit no filesystems get the default ones.
+	stored := *cfg
+	if stored.FileSystems == nil && len(resolved.FileSystems) == 0 {
+		stored.FileSystems = defaultFilesystems
+	}
+
+	if err := resolved.validateResolved(); err != nil {
+		return err
+	}
+
+	if err := c.validateDependents("tenant:"+name, &stored); err != nil {
+		return err
+	}
+
+	value, err := marshalRecord(rootTenant, &stored)
+	if err != nil {
+		return err
+	}
+
+	opts := SetOptions{PrevExist: PrevIgnore}
+	if cfg.Revision != 0 {
+		opts = SetOptions{PrevExist: PrevExist, PrevRevision: cfg.Revision}
+	}
+
+	if _, err := c.store.Set(c.tenant(name), string(value), opts); err != nil {
+		return conflict(err)
+	}
+
+	return nil
+}
+
+// validateResolved validates the effective configuration of a tenant.
+func (cfg *TenantConfig) validateResolved() error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.validateQuota(); err != nil {
+		return err
+	}
+
+	if err := cfg.validatePolicy(); err != nil {
+		return err
+	}
+
+	return cfg.validateProfiles()
+}
 
-	if _, err := c.etcdClient.Set(context.Background(), c.tenant(name), string(value), &client.SetOptions{PrevExist: client.PrevIgnore}); err != nil {
+// validateDependents validates the tenants which inherit from the layer, named
+// as in the sources of ResolveTenant, as they resolve with cfg in its place.
+func (c *TopLevelConfig) validateDependents(layer string, cfg *TenantConfig) error {
+	tenants, err := c.ListTenants()
+	if err != nil {
 		return err
 	}
 
+	for _, tenant := range tenants {
+		if "tenant:"+tenant == layer {
+			continue
+		}
+
+		tc, err := c.GetTenant(tenant)
+		if err != nil {
+			return err
+		}
+
+		pending := map[string]*TenantConfig{layer: cfg}
+
+		if layer != layerGlobal {
+			inherits, err := c.inherits(tc, layer)
+			if err != nil {
+				return err
+			}
+
+			if !inherits {
+				continue
+			}
+		}
+
+		resolved, _, err := c.resolveTenant(tenant, tc, pending)
+		if err == nil {
+			err = resolved.validateResolved()
+		}
+
+		if err != nil {
+			return fmt.Errorf("Tenant %q inherits this configuration and would become invalid: %v", tenant, err)
+		}
+	}
+
 	return nil
 }
 
-// DeleteTenant removes a tenant from the configuration store.
-func (c *TopLevelConfig) DeleteTenant(name string) error {
-	_, err := c.etcdClient.Delete(context.Background(), c.tenant(name), nil)
-	return err
+// inherits returns whether the tenant configuration tc extends the tenant
+// layer, directly or through other tenants.
+func (c *TopLevelConfig) inherits(tc *TenantConfig, layer string) (bool, error) {
+	seen := map[string]bool{}
+
+	for parent := tc.Extends; parent != "" && !seen[parent]; {
+		if "tenant:"+parent == layer {
+			return true, nil
+		}
+		seen[parent] = true
+
+		ptc, err := c.GetTenant(parent)
+		if err == ErrNotExist {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		parent = ptc.Extends
+	}
+
+	return false, nil
+}
+
+// TenantDependents returns the sorted names of the tenants which extend the tenant
+// name, directly or through other tenants.
+func (c *TopLevelConfig) TenantDependents(name string) ([]string, error) {
+	tenants, err := c.ListTenants()
+	if err != nil {
+		return nil, err
+	}
+
+	dependents := []string{}
+
+	for _, tenant := range tenants {
+		if tenant == name {
+			continue
+		}
+
+		tc, err := c.GetTenant(tenant)
+		if err != nil {
+			return nil, err
+		}
+
+		inherits, err := c.inherits(tc, "tenant:"+name)
+		if err != nil {
+			return nil, err
+		}
+
+		if inherits {
+			dependents = append(dependents, tenant)
+		}
+	}
+
+	sort.Strings(dependents)
+
+	return dependents, nil
+}
+
+// DeleteTenant removes a tenant from the configuration store. It is refused
+// while the tenant has volumes, which could no longer be resolved against its
+// policy, or while other tenants extend it, which could no longer be resolved
+// at all. If revision is non-zero, the removal fails with ErrConflict unless
+// the tenant is still at it.
+func (c *TopLevelConfig) DeleteTenant(name string, revision uint64) error {
+	// volumes are created under the lock, so none appear before the tenant is
+	// gone.
+	unlock, err := c.lockTenant(name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	vols, err := c.ListVolumes(name)
+	if err != nil {
+		return err
+	}
+
+	if len(vols) > 0 {
+		return fmt.Errorf("Tenant %q still has %d volume(s); remove them first, or use `volcli tenant delete --cascade`", name, len(vols))
+	}
+
+	dependents, err := c.TenantDependents(name)
+	if err != nil {
+		return err
+	}
+
+	if len(dependents) > 0 {
+		return fmt.Errorf("Tenant %q is extended by tenant(s) %s; change or remove them first", name, quoteList(dependents))
+	}
+
+	return conflict(c.store.Delete(c.tenant(name), DeleteOptions{PrevRevision: revision}))
 }
 
 // GetTenant retrieves a tenant from the configuration store.
 func (c *TopLevelConfig) GetTenant(name string) (*TenantConfig, error) {
-	resp, err := c.etcdClient.Get(context.Background(), c.tenant(name), nil)
+	entry, err := c.store.Get(c.tenant(name))
 	if err != nil {
 		return nil, err
 	}
 
 	tc := &TenantConfig{}
-	err = json.Unmarshal([]byte(resp.Node.Value), tc)
+	if err := unmarshalRecord(rootTenant, []byte(entry.Value), tc); err != nil {
+		return nil, err
+	}
 
-	return tc, err
+	tc.Revision = entry.Revision
+
+	return tc, nil
 }
 
 // ListTenants provides an array of strings corresponding to the name of each
 // tenant.
 func (c *TopLevelConfig) ListTenants() ([]string, error) {
-	resp, err := c.etcdClient.Get(context.Background(), c.prefixed(rootTenant), &client.GetOptions{Recursive: true, Sort: true})
+	entries, err := c.store.List(c.prefixed(rootTenant))
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.Node == nil {
-		return nil, fmt.Errorf("Tenant's root is missing")
-	}
-
 	tenants := []string{}
 
-	for _, node := range resp.Node.Nodes {
-		tenants = append(tenants, path.Base(node.Key))
+	for _, entry := range entries {
+		tenants = append(tenants, c.relative(rootTenant, entry))
 	}
 
 	return tenants, nil
@@ -95,3 +390,22 @@ func (cfg *TenantConfig) Validate() error {
 
 	return cfg.DefaultVolumeOptions.Validate()
 }
+
+// validateQuota ensures volumes created with the default options fit the
+// quota.
+func (cfg *TenantConfig) validateQuota() error {
+	quota := cfg.Quota
+	if quota == nil {
+		return nil
+	}
+
+	if quota.MaxVolumeSize != 0 && cfg.DefaultVolumeOptions.Size > quota.MaxVolumeSize {
+		return fmt.Errorf("Default size %d MB is larger than the quota's max-volume-size of %d MB", cfg.DefaultVolumeOptions.Size, quota.MaxVolumeSize)
+	}
+
+	if quota.MaxTotalSize != 0 && cfg.DefaultVolumeOptions.Size > quota.MaxTotalSize {
+		return fmt.Errorf("Default size %d MB is larger than the quota's max-total-size of %d MB", cfg.DefaultVolumeOptions.Size, quota.MaxTotalSize)
+	}
+
+	return nil
+}
@@ -1,6 +1,10 @@
 package config
 
-import . "gopkg.in/check.v1"
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
 
 var testTenantConfigs = map[string]*TenantConfig{
 	"basic": {
@@ -31,18 +35,24 @@ var testTenantConfigs = map[string]*TenantConfig{
 	},
 }
 
+// getTenant gets the tenant and checks it carries a revision, which it
+// clears for comparison with the tenant published.
+func (s *configSuite) getTenant(c *C, name string) *TenantConfig {
+	tc, err := s.tlc.GetTenant(name)
+	c.Assert(err, IsNil)
+	c.Assert(tc.Revision, Not(Equals), uint64(0))
+	tc.Revision = 0
+
+	return tc
+}
+
 func (s *configSuite) TestBasicTenant(c *C) {
 	c.Assert(s.tlc.PublishTenant("quux", testTenantConfigs["basic"]), IsNil)
 
-	cfg, err := s.tlc.GetTenant("quux")
-	c.Assert(err, IsNil)
-	c.Assert(cfg, DeepEquals, testTenantConfigs["basic"])
+	c.Assert(s.getTenant(c, "quux"), DeepEquals, testTenantConfigs["basic"])
 
 	c.Assert(s.tlc.PublishTenant("bar", testTenantConfigs["basic2"]), IsNil)
-
-	cfg, err = s.tlc.GetTenant("bar")
-	c.Assert(err, IsNil)
-	c.Assert(cfg, DeepEquals, testTenantConfigs["basic2"])
+	c.Assert(s.getTenant(c, "bar"), DeepEquals, testTenantConfigs["basic2"])
 
 	tenants, err := s.tlc.ListTenants()
 	c.Assert(err, IsNil)
@@ -58,13 +68,11 @@ func (s *configSuite) TestBasicTenant(c *C) {
 		c.Assert(found, Equals, true)
 	}
 
-	c.Assert(s.tlc.DeleteTenant("bar"), IsNil)
+	c.Assert(s.tlc.DeleteTenant("bar", 0), IsNil)
 	_, err = s.tlc.GetTenant("bar")
 	c.Assert(err, NotNil)
 
-	cfg, err = s.tlc.GetTenant("quux")
-	c.Assert(err, IsNil)
-	c.Assert(cfg, DeepEquals, testTenantConfigs["basic"])
+	c.Assert(s.getTenant(c, "quux"), DeepEquals, testTenantConfigs["basic"])
 }
 
 func (s *configSuite) TestTenantValidate(c *C) {
@@ -74,3 +82,62 @@ func (s *configSuite) TestTenantValidate(c *C) {
 
 	c.Assert(testTenantConfigs["nopool"].Validate(), NotNil)
 }
+
+func (s *configSuite) TestTenantRevision(c *C) {
+	c.Assert(s.tlc.PublishTenant("foo", testTenantConfigs["basic"]), IsNil)
+
+	tc, err := s.tlc.GetTenant("foo")
+	c.Assert(err, IsNil)
+	stale := tc.Revision
+
+	tc.DefaultVolumeOptions.Size = 20
+	c.Assert(s.tlc.PublishTenant("foo", tc), IsNil)
+
+	// the first write from the same revision wins.
+	tc.DefaultVolumeOptions.Size = 30
+	tc.Revision = stale
+	c.Assert(s.tlc.PublishTenant("foo", tc), Equals, ErrConflict)
+	c.Assert(s.tlc.DeleteTenant("foo", stale), Equals, ErrConflict)
+
+	current, err := s.tlc.GetTenant("foo")
+	c.Assert(err, IsNil)
+	c.Assert(current.DefaultVolumeOptions.Size, Equals, uint64(20))
+
+	c.Assert(s.tlc.DeleteTenant("foo", current.Revision), IsNil)
+
+	// tenants which do not exist have no revision to match.
+	tc.Revision = current.Revision
+	c.Assert(s.tlc.PublishTenant("foo", tc), Equals, ErrNotExist)
+}
+
+func (s *configSuite) TestTenantDeleteWithVolumes(c *C) {
+	c.Assert(s.tlc.PublishTenant("foo", testTenantConfigs["basic"]), IsNil)
+
+	_, err := s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "bar"})
+	c.Assert(err, IsNil)
+
+	c.Assert(s.tlc.DeleteTenant("foo", 0), ErrorMatches, `Tenant "foo" still has 1 volume\(s\).*`)
+	_, err = s.tlc.GetTenant("foo")
+	c.Assert(err, IsNil)
+
+	c.Assert(s.tlc.RemoveVolume("foo", "bar", 0), IsNil)
+
+	// deletion waits for creates, which hold the tenant's lock.
+	unlock, err := s.tlc.lockTenant("foo")
+	c.Assert(err, IsNil)
+
+	deleted := make(chan error)
+	go func() { deleted <- s.tlc.DeleteTenant("foo", 0) }()
+
+	select {
+	case <-deleted:
+		c.Fatal("tenant was deleted while its lock was held")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	unlock()
+	c.Assert(<-deleted, IsNil)
+
+	_, err = s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "bar"})
+	c.Assert(err, Equals, ErrNotExist)
+}
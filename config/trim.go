@@ -0,0 +1,52 @@
+package config
+
+import (
+	"time"
+)
+
+// TrimStatus records the outcome of the last trim of a volume. It is
+// published by volplugin through the volmaster after each trim.
+type TrimStatus struct {
+	Tenant         string    `json:"tenant"`
+	Volume         string    `json:"volume"`
+	Host           string    `json:"host"`
+	LastTrim       time.Time `json:"last-trim"`
+	BytesReclaimed uint64    `json:"bytes-reclaimed"`
+}
+
+func (c *TopLevelConfig) trim(tenant, name string) string {
+	return c.prefixed(rootTrim, tenant, name)
+}
+
+// PublishTrim records the trim status for a volume, replacing any previous
+// status.
+func (c *TopLevelConfig) PublishTrim(ts *TrimStatus) error {
+	content, err := marshalRecord(rootTrim, ts)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.store.Set(c.trim(ts.Tenant, ts.Volume), string(content), SetOptions{PrevExist: PrevIgnore})
+	return err
+}
+
+// GetTrim retrieves the trim status for a volume.
+func (c *TopLevelConfig) GetTrim(tenant, name string) (*TrimStatus, error) {
+	entry, err := c.store.Get(c.trim(tenant, name))
+	if err != nil {
+		return nil, err
+	}
+
+	ts := &TrimStatus{}
+	if err := unmarshalRecord(rootTrim, []byte(entry.Value), ts); err != nil {
+		return nil, err
+	}
+
+	return ts, nil
+}
+
+// RemoveTrim removes the trim status for a volume. Used when the volume is
+// removed.
+func (c *TopLevelConfig) RemoveTrim(tenant, name string) error {
+	return c.store.Delete(c.trim(tenant, name), DeleteOptions{})
+}
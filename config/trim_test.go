@@ -0,0 +1,37 @@
+package config
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *configSuite) TestTrimCRUD(c *C) {
+	ts := &TrimStatus{
+		Tenant:         "foo",
+		Volume:         "bar",
+		Host:           "hostname",
+		LastTrim:       time.Now().UTC().Truncate(time.Second),
+		BytesReclaimed: 1024,
+	}
+
+	_, err := s.tlc.GetTrim("foo", "bar")
+	c.Assert(err, NotNil)
+
+	c.Assert(s.tlc.PublishTrim(ts), IsNil)
+
+	ts2, err := s.tlc.GetTrim("foo", "bar")
+	c.Assert(err, IsNil)
+	c.Assert(ts2, DeepEquals, ts)
+
+	ts.BytesReclaimed = 2048
+	c.Assert(s.tlc.PublishTrim(ts), IsNil)
+
+	ts2, err = s.tlc.GetTrim("foo", "bar")
+	c.Assert(err, IsNil)
+	c.Assert(ts2.BytesReclaimed, Equals, uint64(2048))
+
+	c.Assert(s.tlc.RemoveTrim("foo", "bar"), IsNil)
+	_, err = s.tlc.GetTrim("foo", "bar")
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,231 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sizeUnits are the multipliers of the size suffixes, in MB. Units are powers
+// of 1024.
+var sizeUnits = map[string]uint64{
+	"":   1,
+	"M":  1,
+	"MB": 1,
+	"G":  1 << 10,
+	"GB": 1 << 10,
+	"T":  1 << 20,
+	"TB": 1 << 20,
+	"P":  1 << 30,
+	"PB": 1 << 30,
+}
+
+// parseSize parses a size in MB. The size may carry a unit, such as 512M, 10G
+// or 1.5T; a plain number is in MB.
+func parseSize(str string) (uint64, error) {
+	str = strings.TrimSpace(str)
+
+	split := strings.IndexFunc(str, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if split == -1 {
+		split = len(str)
+	}
+
+	multiplier, ok := sizeUnits[strings.ToUpper(strings.TrimSpace(str[split:]))]
+	if !ok {
+		return 0, fmt.Errorf("Invalid size %q: unknown unit %q, use M, G, T or P", str, str[split:])
+	}
+
+	if !strings.Contains(str[:split], ".") {
+		num, err := strconv.ParseUint(str[:split], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid size %q", str)
+		}
+
+		return num * multiplier, nil
+	}
+
+	num, err := strconv.ParseFloat(str[:split], 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid size %q", str)
+	}
+
+	size := num * float64(multiplier)
+	if size != float64(uint64(size)) {
+		return 0, fmt.Errorf("Invalid size %q: not a whole number of MB", str)
+	}
+
+	return uint64(size), nil
+}
+
+// sizeValue is a size in MB which decodes from JSON either as a number of MB
+// or as a string with a unit.
+type sizeValue uint64
+
+func (s *sizeValue) UnmarshalJSON(content []byte) error {
+	var str string
+	if err := json.Unmarshal(content, &str); err != nil {
+		var num uint64
+		if err := json.Unmarshal(content, &num); err != nil {
+			return fmt.Errorf("Invalid size %s", string(content))
+		}
+
+		*s = sizeValue(num)
+		return nil
+	}
+
+	size, err := parseSize(str)
+	if err != nil {
+		return err
+	}
+
+	*s = sizeValue(size)
+	return nil
+}
+
+// ParseFrequency parses a frequency in Go's duration notation, as used by the
+// snapshot and trim options. Frequencies shorter than a second are refused.
+func ParseFrequency(freq string) (time.Duration, error) {
+	duration, err := time.ParseDuration(freq)
+	if err != nil {
+		return 0, err
+	}
+
+	if duration < time.Second {
+		return 0, fmt.Errorf("%q is shorter than a second", freq)
+	}
+
+	return duration, nil
+}
+
+// Interval returns the frequency of the snapshots as a duration.
+func (s SnapshotConfig) Interval() (time.Duration, error) {
+	return ParseFrequency(s.Frequency)
+}
+
+// UnmarshalJSON decodes VolumeOptions, accepting sizes with units.
+func (opts *VolumeOptions) UnmarshalJSON(content []byte) error {
+	type plain VolumeOptions
+
+	aux := struct {
+		*plain
+		Size *sizeValue `json:"size"`
+	}{plain: (*plain)(opts)}
+
+	if err := json.Unmarshal(content, &aux); err != nil {
+		return err
+	}
+
+	if aux.Size != nil {
+		opts.Size = uint64(*aux.Size)
+	}
+
+	return nil
+}
+
+// UnmarshalJSON decodes QuotaConfig, accepting sizes with units.
+func (q *QuotaConfig) UnmarshalJSON(content []byte) error {
+	type plain QuotaConfig
+
+	aux := struct {
+		*plain
+		MaxTotalSize  *sizeValue `json:"max-total-size"`
+		MaxVolumeSize *sizeValue `json:"max-volume-size"`
+	}{plain: (*plain)(q)}
+
+	if err := json.Unmarshal(content, &aux); err != nil {
+		return err
+	}
+
+	if aux.MaxTotalSize != nil {
+		q.MaxTotalSize = uint64(*aux.MaxTotalSize)
+	}
+
+	if aux.MaxVolumeSize != nil {
+		q.MaxVolumeSize = uint64(*aux.MaxVolumeSize)
+	}
+
+	return nil
+}
+
+// UnmarshalJSON decodes OptionPolicy. Bounds given as strings are kept
+// until parseBounds knows the option they bound, as only sizes take units.
+func (policy *OptionPolicy) UnmarshalJSON(content []byte) error {
+	type plain OptionPolicy
+
+	aux := struct {
+		*plain
+		Min json.RawMessage `json:"min"`
+		Max json.RawMessage `json:"max"`
+	}{plain: (*plain)(policy)}
+
+	if err := json.Unmarshal(content, &aux); err != nil {
+		return err
+	}
+
+	for _, bound := range []struct {
+		raw   json.RawMessage
+		value *uint64
+		text  *string
+	}{
+		{aux.Min, &policy.Min, &policy.minText},
+		{aux.Max, &policy.Max, &policy.maxText},
+	} {
+		if bound.raw == nil {
+			continue
+		}
+
+		if err := json.Unmarshal(bound.raw, bound.text); err == nil {
+			continue
+		}
+
+		if err := json.Unmarshal(bound.raw, bound.value); err != nil {
+			return fmt.Errorf("Invalid policy bound %s", string(bound.raw))
+		}
+	}
+
+	return nil
+}
+
+// parseBounds parses the bounds of the policy for option key which were given
+// as strings. Bounds of sizes may carry a unit; those of other options are
+// plain numbers.
+func (policy *OptionPolicy) parseBounds(key string) error {
+	_, field, _ := lookupField(reflect.ValueOf(VolumeOptions{}), key)
+
+	for _, bound := range []struct {
+		value *uint64
+		text  *string
+	}{
+		{&policy.Min, &policy.minText},
+		{&policy.Max, &policy.maxText},
+	} {
+		if *bound.text == "" {
+			continue
+		}
+
+		if field.Tag.Get("unit") == "size" {
+			size, err := parseSize(*bound.text)
+			if err != nil {
+				return fmt.Errorf("Policy for option %q: %v", key, err)
+			}
+
+			*bound.value = size
+		} else {
+			num, err := strconv.ParseUint(strings.TrimSpace(*bound.text), 10, 64)
+			if err != nil {
+				return fmt.Errorf("Policy for option %q: invalid bound %q; only sizes take units", key, *bound.text)
+			}
+
+			*bound.value = num
+		}
+
+		*bound.text = ""
+	}
+
+	return nil
+}
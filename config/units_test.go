@@ -0,0 +1,109 @@
+package config
+
+import (
+	"encoding/json"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *configSuite) TestParseSize(c *C) {
+	for str, size := range map[string]uint64{
+		"10":    10,
+		"512M":  512,
+		"512mb": 512,
+		"10G":   10240,
+		"10 GB": 10240,
+		"1T":    1048576,
+		"1.5G":  1536,
+		"1P":    1073741824,
+	} {
+		parsed, err := parseSize(str)
+		c.Assert(err, IsNil, Commentf(str))
+		c.Assert(parsed, Equals, size, Commentf(str))
+	}
+
+	for _, str := range []string{"", "G", "10K", "10X", "-1", "1.5", "1.0001G", "1.2.3G"} {
+		_, err := parseSize(str)
+		c.Assert(err, NotNil, Commentf(str))
+	}
+}
+
+func (s *configSuite) TestMergeSize(c *C) {
+	v := VolumeOptions{}
+	c.Assert(mergeOpts(&v, map[string]string{"size": "10G"}), IsNil)
+	c.Assert(v.Size, Equals, uint64(10240))
+
+	c.Assert(mergeOpts(&v, map[string]string{"size": "20"}), IsNil)
+	c.Assert(v.Size, Equals, uint64(20))
+
+	c.Assert(mergeOpts(&v, map[string]string{"size": "10Q"}), NotNil)
+}
+
+func (s *configSuite) TestSizeJSON(c *C) {
+	tc := &TenantConfig{}
+	c.Assert(json.Unmarshal([]byte(`{
+		"default-options": {"pool": "rbd", "size": "10G", "rate-limit": {"write-bps": 100000000}},
+		"quota": {"max-volumes": 5, "max-total-size": "1T", "max-volume-size": 20480},
+		"policy": {"size": {"min": "1G", "max": "100G"}, "rate-limit.write.iops": {"max": 1000}}
+	}`), tc), IsNil)
+
+	c.Assert(tc.DefaultVolumeOptions.Pool, Equals, "rbd")
+	c.Assert(tc.DefaultVolumeOptions.Size, Equals, uint64(10240))
+	c.Assert(tc.DefaultVolumeOptions.RateLimit.WriteBPS, Equals, uint64(100000000))
+	c.Assert(tc.Quota, DeepEquals, &QuotaConfig{MaxVolumes: 5, MaxTotalSize: 1048576, MaxVolumeSize: 20480})
+	c.Assert(tc.Policy["size"], DeepEquals, &OptionPolicy{Min: 1024, Max: 102400})
+	c.Assert(tc.Policy["rate-limit.write.iops"], DeepEquals, &OptionPolicy{Max: 1000})
+
+	// only sizes take units; other bounds may be numbers in strings.
+	c.Assert(json.Unmarshal([]byte(`{"policy": {"rate-limit.write.bps": {"max": "1G"}}}`), &TenantConfig{}), ErrorMatches, `Policy for option "rate-limit.write.bps": invalid bound "1G"; only sizes take units`)
+	bounds := &TenantConfig{}
+	c.Assert(json.Unmarshal([]byte(`{"policy": {"rate-limit.write.iops": {"min": "10", "max": 1000}}}`), bounds), IsNil)
+	c.Assert(bounds.Policy["rate-limit.write.iops"], DeepEquals, &OptionPolicy{Min: 10, Max: 1000})
+
+	// sizes are stored in MB, and read back as such.
+	content, err := json.Marshal(tc.DefaultVolumeOptions)
+	c.Assert(err, IsNil)
+
+	opts := VolumeOptions{}
+	c.Assert(json.Unmarshal(content, &opts), IsNil)
+	c.Assert(opts, DeepEquals, tc.DefaultVolumeOptions)
+
+	c.Assert(json.Unmarshal([]byte(`{"size": "10X"}`), &opts), NotNil)
+	c.Assert(json.Unmarshal([]byte(`{"size": true}`), &opts), NotNil)
+}
+
+func (s *configSuite) TestFrequencyValidation(c *C) {
+	tc := &TenantConfig{
+		DefaultVolumeOptions: VolumeOptions{
+			Pool:         "rbd",
+			Size:         10,
+			UseSnapshots: true,
+			Snapshot:     SnapshotConfig{Frequency: "every hour", Keep: 10},
+		},
+	}
+
+	c.Assert(s.tlc.PublishTenant("foo", tc), ErrorMatches, `Invalid snapshot frequency "every hour".*`)
+
+	tc.DefaultVolumeOptions.Snapshot.Frequency = "10ms"
+	c.Assert(s.tlc.PublishTenant("foo", tc), ErrorMatches, `Invalid snapshot frequency "10ms".*`)
+
+	tc.DefaultVolumeOptions.Snapshot.Frequency = "1h"
+	c.Assert(s.tlc.PublishTenant("foo", tc), IsNil)
+
+	_, err := s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "bar", Opts: map[string]string{"snapshots.frequency": "1x"}})
+	c.Assert(err, ErrorMatches, `Invalid snapshot frequency "1x".*`)
+
+	_, err = s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "bar", Opts: map[string]string{"trim": "soon"}})
+	c.Assert(err, ErrorMatches, `Invalid trim frequency "soon".*`)
+
+	vc, err := s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "bar", Opts: map[string]string{"snapshots.frequency": "30m", "size": "1G"}})
+	c.Assert(err, IsNil)
+	defer s.tlc.RemoveVolume("foo", "bar", 0)
+
+	interval, err := vc.Options.Snapshot.Interval()
+	c.Assert(err, IsNil)
+	c.Assert(interval.Minutes(), Equals, float64(30))
+	c.Assert(vc.Options.Size, Equals, uint64(1024))
+
+	c.Assert(s.tlc.PublishGlobal(&TenantConfig{DefaultVolumeOptions: VolumeOptions{Trim: "-1h"}}), NotNil)
+}
@@ -1,13 +1,9 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
 	"path"
-	"strings"
-
-	"github.com/coreos/etcd/client"
-	"golang.org/x/net/context"
+	"sort"
 )
 
 // VolumeConfig is the configuration of the tenant. It includes pool and
@@ -15,32 +11,57 @@ import (
 type VolumeConfig struct {
 	TenantName string         `json:"tenant"`
 	VolumeName string         `json:"name"`
+	Image      string         `json:"image,omitempty"`
 	Options    *VolumeOptions `json:"options"`
+
+	// Profile is the tenant profile the volume was created with, if any.
+	Profile string `json:"profile,omitempty"`
+
+	// Overrides are the merge keys of the options which were set for this
+	// volume, rather than inherited from the tenant's default options. They
+	// are nil for volumes created before overrides were recorded, whose
+	// overrides are unknown.
+	Overrides []string `json:"overrides"`
+
+	// State is the lifecycle state of the volume; StateError is the error
+	// which moved it to StateFailed.
+	State      VolumeState `json:"state,omitempty"`
+	StateError string      `json:"state-error,omitempty"`
+
+	// Revision is the revision of the record the volume was read from. It is
+	// not part of the record. Writes of the volume expect the record to be at
+	// this revision still, unless it is zero.
+	Revision uint64 `json:"-"`
 }
 
-// VolumeOptions comprises the optional paramters a volume can accept.
+// VolumeOptions comprises the optional paramters a volume can accept. The
+// `merge` tags name the options users pass with `docker volume create --opt`;
+// the `desc` and `unit` tags describe them in the option catalog.
 type VolumeOptions struct {
-	Pool         string          `json:"pool" merge:"pool"`
-	Size         uint64          `json:"size" merge:"size"`
-	UseSnapshots bool            `json:"snapshots" merge:"snapshots"`
-	Snapshot     SnapshotConfig  `json:"snapshot"`
-	FileSystem   string          `json:"filesystem" merge:"filesystem"`
-	Ephemeral    bool            `json:"ephemeral,omitempty" merge:"ephemeral"`
-	RateLimit    RateLimitConfig `json:"rate-limit,omitempty"`
+	Pool           string          `json:"pool" merge:"pool" desc:"Ceph pool the volume is created in"`
+	Size           uint64          `json:"size" merge:"size" unit:"size" desc:"Size of the volume, in MB or with a unit of M, G, T or P"`
+	UseSnapshots   bool            `json:"snapshots" merge:"snapshots" desc:"Take snapshots of the volume periodically"`
+	Snapshot       SnapshotConfig  `json:"snapshot"`
+	FileSystem     string          `json:"filesystem" merge:"filesystem" desc:"Filesystem to create, one of the tenant's filesystems"`
+	Ephemeral      bool            `json:"ephemeral,omitempty" merge:"ephemeral" desc:"Remove the volume when it is removed from docker"`
+	MapMode        string          `json:"map-mode,omitempty" merge:"map-mode" desc:"How the image is mapped on the host, krbd or nbd"`
+	SharedReadOnly bool            `json:"shared-readonly,omitempty" merge:"shared-readonly" desc:"Mount the volume read-only, on any number of hosts at once"`
+	Trim           string          `json:"trim,omitempty" merge:"trim" unit:"duration" desc:"Frequency of trimming the volume while it is mounted"`
+	RateLimit      RateLimitConfig `json:"rate-limit,omitempty"`
 }
 
 // RateLimitConfig is the configuration for limiting the rate of disk access.
 type RateLimitConfig struct {
-	WriteIOPS uint   `json:"write-iops" merge:"rate-limit.write.iops"`
-	ReadIOPS  uint   `json:"read-iops" merge:"rate-limit.read.iops"`
-	WriteBPS  uint64 `json:"write-bps" merge:"rate-limit.write.bps"`
-	ReadBPS   uint64 `json:"read-bps" merge:"rate-limit.read.bps"`
+	WriteIOPS uint   `json:"write-iops" merge:"rate-limit.write.iops" desc:"Write operations per second"`
+	ReadIOPS  uint   `json:"read-iops" merge:"rate-limit.read.iops" desc:"Read operations per second"`
+	WriteBPS  uint64 `json:"write-bps" merge:"rate-limit.write.bps" desc:"Bytes written per second"`
+	ReadBPS   uint64 `json:"read-bps" merge:"rate-limit.read.bps" desc:"Bytes read per second"`
 }
 
 // SnapshotConfig is the configuration for snapshots.
 type SnapshotConfig struct {
-	Frequency string `json:"frequency" merge:"snapshots.frequency"`
-	Keep      uint   `json:"keep" merge:"snapshots.keep"`
+	Frequency string `json:"frequency" merge:"snapshots.frequency" unit:"duration" desc:"Frequency of snapshots"`
+	Keep      uint   `json:"keep" merge:"snapshots.keep" desc:"Number of snapshots to keep"`
 }
 
 func (c *TopLevelConfig) volume(tenant, name string) string {
@@ -48,30 +69,73 @@ func (c *TopLevelConfig) volume(tenant, name string) string {
 }
 
 // CreateVolume sets the appropriate config metadata for a volume creation
-// operation, and returns the VolumeConfig that was copied in.
+// operation, and returns the VolumeConfig that was copied in. The volume is
+// StateCreating until it is transitioned once its image exists.
 func (c *TopLevelConfig) CreateVolume(rc RequestCreate) (*VolumeConfig, error) {
+	return c.createVolume(rc, "", true)
+}
+
+// AdoptVolume is like CreateVolume, but records the name of a pre-existing
+// image which backs the volume instead of the default name.
+func (c *TopLevelConfig) AdoptVolume(rc RequestCreate, image string) (*VolumeConfig, error) {
+	return c.createVolume(rc, image, false)
+}
+
+// createVolume creates the volume. The tenant's policy on the options users
+// may override is only enforced if enforcePolicy is set; adopted volumes take
+// their options from the image instead of the user.
+func (c *TopLevelConfig) createVolume(rc RequestCreate, image string, enforcePolicy bool) (*VolumeConfig, error) {
+	// volumes named before the current rules exist, so docker may create them.
 	v, _ := c.GetVolume(rc.Tenant, rc.Volume)
 	if v != nil {
 		return v, ErrExist
 	}
 
-	resp, err := c.GetTenant(rc.Tenant)
+	if err := ValidateName("tenant", rc.Tenant); err != nil {
+		return nil, err
+	}
+
+	if err := ValidateName("volume", rc.Volume); err != nil {
+		return nil, err
+	}
+
+	resp, _, err := c.ResolveTenant(rc.Tenant)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := mergeOpts(&resp.DefaultVolumeOptions, rc.Opts); err != nil {
+	profile, opts := splitProfile(rc.Opts)
+
+	if err := resp.applyProfile(rc.Tenant, profile, &resp.DefaultVolumeOptions); err != nil {
+		return nil, err
+	}
+
+	if err := mergeOpts(&resp.DefaultVolumeOptions, opts); err != nil {
 		return nil, err
 	}
 
+	if enforcePolicy {
+		if err := resp.checkPolicy(rc.Tenant, opts, &resp.DefaultVolumeOptions); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := resp.Validate(); err != nil {
 		return nil, err
 	}
 
+	if image == "" {
+		image = ImageName(rc.Tenant, rc.Volume)
+	}
+
 	vc := &VolumeConfig{
 		Options:    &resp.DefaultVolumeOptions,
 		TenantName: rc.Tenant,
 		VolumeName: rc.Volume,
+		Image:      image,
+		Profile:    profile,
+		Overrides:  addOverrides(nil, opts),
+		State:      StateCreating,
 	}
 
 	if err := vc.Validate(); err != nil {
@@ -82,67 +146,260 @@ func (c *TopLevelConfig) CreateVolume(rc RequestCreate) (*VolumeConfig, error) {
 		vc.Options.FileSystem = defaultFilesystem
 	}
 
-	remarshal, err := json.Marshal(vc)
+	if resp.Quota != nil {
+		if err := resp.Quota.checkVolume(rc.Tenant, vc.Options); err != nil {
+			return nil, err
+		}
+	}
+
+	// hold the lock until the volume is published, so concurrent creates see
+	// each other's volumes, and the tenant is not deleted underneath.
+	unlock, err := c.lockTenant(rc.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if _, err := c.GetTenant(rc.Tenant); err != nil {
+		return nil, err
+	}
+
+	// no two volumes may share an image, whether created or adopted.
+	unlockImage, err := c.lock(c.prefixed(rootLock, rootImage, vc.Options.Pool, image), fmt.Sprintf("image %q", image))
+	if err != nil {
+		return nil, err
+	}
+	defer unlockImage()
+
+	if err := c.CheckImage(vc.Options.Pool, image); err != nil {
+		return nil, err
+	}
+
+	if resp.Quota != nil {
+		usage, err := c.TenantUsage(rc.Tenant)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := resp.Quota.checkUsage(rc.Tenant, usage, vc.Options); err != nil {
+			return nil, err
+		}
+	}
+
+	remarshal, err := marshalRecord(rootVolume, vc)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := c.store.Set(c.volume(rc.Tenant, rc.Volume), string(remarshal), SetOptions{PrevExist: PrevNoExist})
+	if err != nil {
+		return nil, err
+	}
+
+	vc.Revision = entry.Revision
+
+	return vc, nil
+}
+
+// UpdateVolume merges the options in the request into the options of an
+// existing volume and writes the result back. The write fails with
+// ErrConflict if the volume is not at the revision in the request, or was
+// modified concurrently.
+func (c *TopLevelConfig) UpdateVolume(ru RequestUpdate) (*VolumeConfig, error) {
+	entry, err := c.store.Get(c.volume(ru.Tenant, ru.Volume))
+	if err != nil {
+		return nil, err
+	}
+
+	if ru.Revision != 0 && ru.Revision != entry.Revision {
+		return nil, ErrConflict
+	}
+
+	vc := &VolumeConfig{}
+	if err := unmarshalRecord(rootVolume, []byte(entry.Value), vc); err != nil {
+		return nil, err
+	}
+
+	opts := *vc.Options
+	if err := mergeOpts(&opts, ru.Opts); err != nil {
+		return nil, err
+	}
+
+	if err := checkImmutable(vc.Options, &opts); err != nil {
+		return nil, err
+	}
+
+	if opts.MapMode != vc.Options.MapMode {
+		if err := c.checkRemap(ru.Tenant, ru.Volume); err != nil {
+			return nil, err
+		}
+	}
+
+	// volumes may outlive their tenant, in which case there is no policy or
+	// quota to check.
+	tc, _, err := c.ResolveTenant(ru.Tenant)
 	if err != nil {
+		tc = nil
+	}
+
+	if tc != nil {
+		if err := tc.checkPolicy(ru.Tenant, ru.Opts, &opts); err != nil {
+			return nil, err
+		}
+	}
+
+	vc.Options = &opts
+
+	// the overrides of volumes which predate them stay unknown.
+	if vc.Overrides != nil {
+		vc.Overrides = addOverrides(vc.Overrides, ru.Opts)
+	}
+
+	if err := vc.Validate(); err != nil {
 		return nil, err
 	}
 
-	c.etcdClient.Set(context.Background(), c.prefixed(rootVolume, rc.Tenant), "", &client.SetOptions{Dir: true})
+	if tc != nil && tc.Quota != nil {
+		if err := tc.Quota.checkVolume(ru.Tenant, vc.Options); err != nil {
+			return nil, err
+		}
+	}
 
-	if _, err := c.etcdClient.Set(context.Background(), c.volume(rc.Tenant, rc.Volume), string(remarshal), &client.SetOptions{PrevExist: client.PrevNoExist}); err != nil {
+	remarshal, err := marshalRecord(rootVolume, vc)
+	if err != nil {
 		return nil, err
 	}
 
+	entry, err = c.store.Set(entry.Key, string(remarshal), SetOptions{PrevExist: PrevExist, PrevRevision: entry.Revision})
+	if err != nil {
+		return nil, conflict(err)
+	}
+
+	vc.Revision = entry.Revision
+
 	return vc, nil
 }
 
+// addOverrides returns the sorted union of overrides and the keys of opts.
+// The result is never nil, so that the volume is known to record overrides.
+func addOverrides(overrides []string, opts map[string]string) []string {
+	keys := map[string]bool{}
+	for _, key := range overrides {
+		keys[key] = true
+	}
+
+	for key := range opts {
+		keys[key] = true
+	}
+
+	ret := []string{}
+	for key := range keys {
+		ret = append(ret, key)
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+// checkImmutable returns an error if an option which only takes effect when
+// the image is created differs between old and new.
+func checkImmutable(old, new *VolumeOptions) error {
+	if old.Pool != new.Pool {
+		return fmt.Errorf("Option \"pool\" cannot be changed on an existing volume; use `volcli volume migrate` to move it to another pool")
+	}
+
+	if old.FileSystem != new.FileSystem {
+		return fmt.Errorf("Option \"filesystem\" cannot be changed on an existing volume")
+	}
+
+	if old.Size != new.Size {
+		return fmt.Errorf("Option \"size\" cannot be changed on an existing volume")
+	}
+
+	return nil
+}
+
+// checkRemap returns an error if the volume has mount holders, as its
+// map-mode cannot change while the image is mapped the old way.
+func (c *TopLevelConfig) checkRemap(tenant, name string) error {
+	holders, err := c.MountHolders(tenant, name)
+	if err != nil {
+		return err
+	}
+
+	if len(holders) > 0 {
+		return fmt.Errorf("Option \"map-mode\" cannot be changed while volume %q of tenant %q is mounted on host %q", name, tenant, holders[0].Host)
+	}
+
+	return nil
+}
+
 // GetVolume returns the VolumeConfig for a given volume.
 func (c *TopLevelConfig) GetVolume(tenant, name string) (*VolumeConfig, error) {
-	resp, err := c.etcdClient.Get(context.Background(), c.volume(tenant, name), nil)
+	entry, err := c.store.Get(c.volume(tenant, name))
 	if err != nil {
 		return nil, err
 	}
 
 	ret := &VolumeConfig{}
 
-	if err := json.Unmarshal([]byte(resp.Node.Value), ret); err != nil {
+	if err := unmarshalRecord(rootVolume, []byte(entry.Value), ret); err != nil {
 		return nil, err
 	}
 
+	ret.Revision = entry.Revision
+
 	return ret, nil
 }
 
-// RemoveVolume removes a volume from configuration.
-func (c *TopLevelConfig) RemoveVolume(tenant, name string) error {
-	// FIXME might be a consistency issue here; pass around volume structs instead.
-	_, err := c.etcdClient.Delete(context.Background(), c.prefixed(rootVolume, tenant, name), &client.DeleteOptions{})
-	return err
+// PublishVolume writes an updated VolumeConfig for an existing volume. If the
+// volume carries a revision, the write fails with ErrConflict unless the
+// record is still at it. The volume's revision is updated on success.
+func (c *TopLevelConfig) PublishVolume(vc *VolumeConfig) error {
+	if err := vc.Validate(); err != nil {
+		return err
+	}
+
+	remarshal, err := marshalRecord(rootVolume, vc)
+	if err != nil {
+		return err
+	}
+
+	entry, err := c.store.Set(c.volume(vc.TenantName, vc.VolumeName), string(remarshal), SetOptions{PrevExist: PrevExist, PrevRevision: vc.Revision})
+	if err != nil {
+		return conflict(err)
+	}
+
+	vc.Revision = entry.Revision
+
+	return nil
+}
+
+// RemoveVolume removes a volume from configuration. If revision is non-zero,
+// the removal fails with ErrConflict unless the record is still at it.
+func (c *TopLevelConfig) RemoveVolume(tenant, name string, revision uint64) error {
+	return conflict(c.store.Delete(c.volume(tenant, name), DeleteOptions{PrevRevision: revision}))
 }
 
 // ListVolumes returns a map of volume name -> VolumeConfig.
 func (c *TopLevelConfig) ListVolumes(tenant string) (map[string]*VolumeConfig, error) {
-	tenantPath := c.prefixed(rootVolume, tenant)
-
-	resp, err := c.etcdClient.Get(context.Background(), tenantPath, &client.GetOptions{Recursive: true, Sort: true})
+	entries, err := c.store.List(c.prefixed(rootVolume, tenant))
 	if err != nil {
 		return nil, err
 	}
 
 	configs := map[string]*VolumeConfig{}
 
-	for _, node := range resp.Node.Nodes {
-		if node.Value == "" {
-			continue
-		}
-
+	for _, entry := range entries {
 		config := &VolumeConfig{}
-		if err := json.Unmarshal([]byte(node.Value), config); err != nil {
+		if err := unmarshalRecord(rootVolume, []byte(entry.Value), config); err != nil {
 			return nil, err
 		}
 
-		key := strings.TrimPrefix(node.Key, tenantPath)
-		// trim leading slash
-		configs[key[1:]] = config
+		config.Revision = entry.Revision
+
+		configs[path.Base(entry.Key)] = config
 	}
 
 	return configs, nil
@@ -152,22 +409,31 @@ func (c *TopLevelConfig) ListVolumes(tenant string) (map[string]*VolumeConfig, e
 // volmaster knows about. Volumes have syntax: tenant/volumeName which will be
 // reflected in the returned string.
 func (c *TopLevelConfig) ListAllVolumes() ([]string, error) {
-	resp, err := c.etcdClient.Get(context.Background(), c.prefixed(rootVolume), &client.GetOptions{Recursive: true, Sort: true})
+	entries, err := c.store.List(c.prefixed(rootVolume))
 	if err != nil {
 		return nil, err
 	}
 
 	ret := []string{}
 
-	for _, node := range resp.Node.Nodes {
-		for _, innerNode := range node.Nodes {
-			ret = append(ret, path.Join(path.Base(node.Key), path.Base(innerNode.Key)))
-		}
+	for _, entry := range entries {
+		ret = append(ret, c.relative(rootVolume, entry))
 	}
 
 	return ret, nil
 }
 
+// ImageName returns the name of the RBD image backing the volume. This is
+// the image recorded when the volume was created or adopted; volumes created
+// by older releases have none recorded and use `tenant.volume`.
+func (cfg *VolumeConfig) ImageName() string {
+	if cfg.Image != "" {
+		return cfg.Image
+	}
+
+	return legacyImageName(cfg.TenantName, cfg.VolumeName)
+}
+
 // Validate options for a volume. Should be called anytime options are
 // considered.
 func (opts *VolumeOptions) Validate() error {
@@ -183,6 +449,33 @@ func (opts *VolumeOptions) Validate() error {
 		return fmt.Errorf("Snapshots are configured but cannot be used due to blank settings")
 	}
 
+	if err := opts.validateDurations(); err != nil {
+		return err
+	}
+
+	switch opts.MapMode {
+	case "", "krbd", "nbd":
+	default:
+		return fmt.Errorf("Invalid map mode %q: must be krbd or nbd", opts.MapMode)
+	}
+
+	return nil
+}
+
+// validateDurations checks the frequencies which are set parse.
+func (opts *VolumeOptions) validateDurations() error {
+	if opts.Snapshot.Frequency != "" {
+		if _, err := opts.Snapshot.Interval(); err != nil {
+			return fmt.Errorf("Invalid snapshot frequency %q: %v", opts.Snapshot.Frequency, err)
+		}
+	}
+
+	if opts.Trim != "" {
+		if _, err := ParseFrequency(opts.Trim); err != nil {
+			return fmt.Errorf("Invalid trim frequency %q: %v", opts.Trim, err)
+		}
+	}
+
 	return nil
 }
 
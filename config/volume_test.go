@@ -59,6 +59,18 @@ func (s *configSuite) TestVolumeOptionsValidate(c *C) {
 	c.Assert(opts.Validate(), NotNil)
 	opts = &VolumeOptions{Size: 10, UseSnapshots: true, Snapshot: SnapshotConfig{Frequency: "10m", Keep: 10}, Pool: "rbd"}
 	c.Assert(opts.Validate(), IsNil)
+
+	opts = &VolumeOptions{Size: 10, Pool: "rbd", MapMode: "krbd"}
+	c.Assert(opts.Validate(), IsNil)
+	opts = &VolumeOptions{Size: 10, Pool: "rbd", MapMode: "nbd"}
+	c.Assert(opts.Validate(), IsNil)
+	opts = &VolumeOptions{Size: 10, Pool: "rbd", MapMode: "fuse"}
+	c.Assert(opts.Validate(), NotNil)
+
+	opts = &VolumeOptions{Size: 10, Pool: "rbd", Trim: "24h"}
+	c.Assert(opts.Validate(), IsNil)
+	opts = &VolumeOptions{Size: 10, Pool: "rbd", Trim: "daily"}
+	c.Assert(opts.Validate(), NotNil)
 }
 
 func (s *configSuite) TestVolumeCRUD(c *C) {
@@ -73,7 +85,7 @@ func (s *configSuite) TestVolumeCRUD(c *C) {
 			vcfg, err := s.tlc.CreateVolume(RequestCreate{Tenant: tenant, Volume: volume})
 			c.Assert(err, IsNil)
 
-			defer func(tenant, volume string) { c.Assert(s.tlc.RemoveVolume(tenant, volume), IsNil) }(tenant, volume)
+			defer func(tenant, volume string) { c.Assert(s.tlc.RemoveVolume(tenant, volume, 0), IsNil) }(tenant, volume)
 
 			c.Assert(vcfg.VolumeName, Equals, volume)
 			opts := testTenantConfigs["basic"].DefaultVolumeOptions
@@ -117,3 +129,178 @@ func (s *configSuite) TestVolumeCRUD(c *C) {
 
 	c.Assert(allNames, DeepEquals, allVols)
 }
+
+func (s *configSuite) TestVolumeAdopt(c *C) {
+	c.Assert(s.tlc.PublishTenant("foo", testTenantConfigs["basic"]), IsNil)
+
+	vcfg, err := s.tlc.AdoptVolume(RequestCreate{Tenant: "foo", Volume: "bar", Opts: map[string]string{"size": "30", "filesystem": "xfs"}}, "legacy")
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(s.tlc.RemoveVolume("foo", "bar", 0), IsNil) }()
+
+	c.Assert(vcfg.ImageName(), Equals, "legacy")
+	c.Assert(vcfg.Options.Size, Equals, uint64(30))
+	c.Assert(vcfg.Options.FileSystem, Equals, "xfs")
+
+	vcfg2, err := s.tlc.GetVolume("foo", "bar")
+	c.Assert(err, IsNil)
+	c.Assert(vcfg, DeepEquals, vcfg2)
+
+	_, err = s.tlc.AdoptVolume(RequestCreate{Tenant: "foo", Volume: "bar"}, "legacy")
+	c.Assert(err, Equals, ErrExist)
+
+	vcfg, err = s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "baz"})
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(s.tlc.RemoveVolume("foo", "baz", 0), IsNil) }()
+	c.Assert(vcfg.ImageName(), Equals, "foo.baz")
+
+	// images back one volume at a time, whether adopted or created.
+	for _, image := range []string{"legacy", "foo.baz"} {
+		_, err = s.tlc.AdoptVolume(RequestCreate{Tenant: "foo", Volume: "quux"}, image)
+		c.Assert(err, ErrorMatches, `Image "`+image+`" in pool "rbd" is already used by volume "ba[rz]" in tenant "foo"`)
+	}
+
+	_, err = s.tlc.AdoptVolume(RequestCreate{Tenant: "foo", Volume: "quux", Opts: map[string]string{"pool": "other"}}, "legacy")
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(s.tlc.RemoveVolume("foo", "quux", 0), IsNil) }()
+
+	_, err = s.tlc.AdoptVolume(RequestCreate{Tenant: "foo", Volume: "adopted"}, "foo.created")
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(s.tlc.RemoveVolume("foo", "adopted", 0), IsNil) }()
+
+	_, err = s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "created"})
+	c.Assert(err, ErrorMatches, `Image "foo.created" in pool "rbd" is already used by volume "adopted" in tenant "foo"`)
+}
+
+func (s *configSuite) TestVolumePublish(c *C) {
+	c.Assert(s.tlc.PublishTenant("foo", testTenantConfigs["basic"]), IsNil)
+
+	vcfg := &VolumeConfig{
+		TenantName: "foo",
+		VolumeName: "bar",
+		Options:    &VolumeOptions{Pool: "rbd", Size: 10},
+	}
+
+	c.Assert(s.tlc.PublishVolume(vcfg), NotNil)
+
+	vcfg, err := s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "bar"})
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(s.tlc.RemoveVolume("foo", "bar", 0), IsNil) }()
+
+	vcfg.Options.Pool = "rbd2"
+	c.Assert(s.tlc.PublishVolume(vcfg), IsNil)
+
+	vcfg2, err := s.tlc.GetVolume("foo", "bar")
+	c.Assert(err, IsNil)
+	c.Assert(vcfg2.Options.Pool, Equals, "rbd2")
+
+	vcfg.Options.Pool = ""
+	c.Assert(s.tlc.PublishVolume(vcfg), NotNil)
+}
+
+func (s *configSuite) TestVolumeUpdate(c *C) {
+	c.Assert(s.tlc.PublishTenant("foo", testTenantConfigs["basic"]), IsNil)
+
+	_, err := s.tlc.UpdateVolume(RequestUpdate{Tenant: "foo", Volume: "bar"})
+	c.Assert(err, Equals, ErrNotExist)
+
+	vcfg, err := s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "bar"})
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(s.tlc.RemoveVolume("foo", "bar", 0), IsNil) }()
+
+	vcfg2, err := s.tlc.UpdateVolume(RequestUpdate{Tenant: "foo", Volume: "bar", Opts: map[string]string{
+		"snapshots":             "true",
+		"snapshots.frequency":   "1h",
+		"snapshots.keep":        "5",
+		"rate-limit.write.iops": "500",
+	}})
+	c.Assert(err, IsNil)
+	c.Assert(vcfg2.Options.UseSnapshots, Equals, true)
+	c.Assert(vcfg2.Options.Snapshot, DeepEquals, SnapshotConfig{Frequency: "1h", Keep: 5})
+	c.Assert(vcfg2.Options.RateLimit.WriteIOPS, Equals, uint(500))
+	c.Assert(vcfg2.Options.Pool, Equals, vcfg.Options.Pool)
+	c.Assert(vcfg2.Overrides, DeepEquals, []string{"rate-limit.write.iops", "snapshots", "snapshots.frequency", "snapshots.keep"})
+
+	vcfg3, err := s.tlc.GetVolume("foo", "bar")
+	c.Assert(err, IsNil)
+	c.Assert(vcfg3, DeepEquals, vcfg2)
+
+	for _, key := range []string{"pool", "filesystem", "size"} {
+		_, err = s.tlc.UpdateVolume(RequestUpdate{Tenant: "foo", Volume: "bar", Opts: map[string]string{key: "100"}})
+		c.Assert(err, ErrorMatches, `Option "`+key+`" cannot be changed.*`)
+	}
+
+	// setting an immutable option to its current value is harmless.
+	_, err = s.tlc.UpdateVolume(RequestUpdate{Tenant: "foo", Volume: "bar", Opts: map[string]string{"pool": vcfg.Options.Pool}})
+	c.Assert(err, IsNil)
+
+	_, err = s.tlc.UpdateVolume(RequestUpdate{Tenant: "foo", Volume: "bar", Opts: map[string]string{"snapshots.keep": "0"}})
+	c.Assert(err, NotNil)
+
+	_, err = s.tlc.UpdateVolume(RequestUpdate{Tenant: "foo", Volume: "bar", Opts: map[string]string{"quux": "1"}})
+	c.Assert(err, NotNil)
+
+	vcfg3, err = s.tlc.GetVolume("foo", "bar")
+	c.Assert(err, IsNil)
+	c.Assert(vcfg3.Options.Snapshot.Keep, Equals, uint(5))
+}
+
+func (s *configSuite) TestVolumeUpdateMapMode(c *C) {
+	s.readyVolume(c, "foo", "quux")
+
+	mt := testMountConfigs["basic"]
+	c.Assert(s.tlc.PublishMount(mt), IsNil)
+
+	_, err := s.tlc.UpdateVolume(RequestUpdate{Tenant: "foo", Volume: "quux", Opts: map[string]string{"map-mode": "nbd"}})
+	c.Assert(err, ErrorMatches, `Option "map-mode" cannot be changed while volume "quux" of tenant "foo" is mounted on host "hostname"`)
+
+	tc := *testTenantConfigs["basic"]
+	tc.DefaultVolumeOptions.MapMode = "nbd"
+	c.Assert(s.tlc.PublishTenant("foo", &tc), IsNil)
+
+	_, err = s.tlc.PlanTenantApply("foo", []string{"map-mode"})
+	c.Assert(err, ErrorMatches, `Option "map-mode" cannot be changed while .*`)
+
+	c.Assert(s.tlc.RemoveMount(mt, false), IsNil)
+
+	plan, err := s.tlc.PlanTenantApply("foo", []string{"map-mode"})
+	c.Assert(err, IsNil)
+	c.Assert(plan, HasLen, 1)
+
+	// a mount made after the plan stops it from being applied.
+	c.Assert(s.tlc.PublishMount(mt), IsNil)
+	failed := s.tlc.ApplyVolumeChanges(plan)
+	c.Assert(failed["quux"], ErrorMatches, `Option "map-mode" cannot be changed while .*`)
+	c.Assert(s.tlc.RemoveMount(mt, false), IsNil)
+
+	vc, err := s.tlc.UpdateVolume(RequestUpdate{Tenant: "foo", Volume: "quux", Opts: map[string]string{"map-mode": "nbd"}})
+	c.Assert(err, IsNil)
+	c.Assert(vc.Options.MapMode, Equals, "nbd")
+}
+
+func (s *configSuite) TestVolumeRevision(c *C) {
+	c.Assert(s.tlc.PublishTenant("foo", testTenantConfigs["basic"]), IsNil)
+
+	vcfg, err := s.tlc.CreateVolume(RequestCreate{Tenant: "foo", Volume: "bar"})
+	c.Assert(err, IsNil)
+	c.Assert(vcfg.Revision, Not(Equals), uint64(0))
+	stale := vcfg.Revision
+
+	vcfg2, err := s.tlc.UpdateVolume(RequestUpdate{Tenant: "foo", Volume: "bar", Opts: map[string]string{"snapshots": "false"}, Revision: stale})
+	c.Assert(err, IsNil)
+	c.Assert(vcfg2.Revision, Not(Equals), stale)
+
+	_, err = s.tlc.UpdateVolume(RequestUpdate{Tenant: "foo", Volume: "bar", Opts: map[string]string{"snapshots": "false"}, Revision: stale})
+	c.Assert(err, Equals, ErrConflict)
+
+	vcfg.Options.Ephemeral = true
+	c.Assert(s.tlc.PublishVolume(vcfg), Equals, ErrConflict)
+	c.Assert(s.tlc.RemoveVolume("foo", "bar", stale), Equals, ErrConflict)
+
+	vols, err := s.tlc.ListVolumes("foo")
+	c.Assert(err, IsNil)
+	c.Assert(vols["bar"].Revision, Equals, vcfg2.Revision)
+
+	vcfg2.Options.Ephemeral = true
+	c.Assert(s.tlc.PublishVolume(vcfg2), IsNil)
+	c.Assert(s.tlc.RemoveVolume("foo", "bar", vcfg2.Revision), IsNil)
+}
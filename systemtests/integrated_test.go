@@ -53,13 +53,13 @@ func (s *systemtestSuite) TestHostLabel(c *C) {
 	defer s.purgeVolume("mon0", "tenant1", "foo", true)
 	defer s.docker("rm -f " + out)
 
-	mt := &config.MountConfig{}
+	holders := []*config.MountConfig{}
 
-	// we know the pool is rbd here, so cheat a little.
-	out, err = s.volcli("mount get rbd foo")
+	out, err = s.volcli("mount get tenant1 foo")
 	c.Assert(err, IsNil)
-	c.Assert(json.Unmarshal([]byte(out), mt), IsNil)
-	c.Assert(mt.Host, Equals, "quux")
+	c.Assert(json.Unmarshal([]byte(out), &holders), IsNil)
+	c.Assert(holders, HasLen, 1)
+	c.Assert(holders[0].Host, Equals, "quux")
 }
 
 func (s *systemtestSuite) TestMountLock(c *C) {
@@ -93,6 +93,82 @@ func (s *systemtestSuite) TestMountLock(c *C) {
 	}
 }
 
+func (s *systemtestSuite) TestMountRefCount(c *C) {
+	c.Assert(s.createVolume("mon0", "tenant1", "test", nil), IsNil)
+	defer s.purgeVolume("mon0", "tenant1", "test", true)
+	defer s.clearContainers()
+
+	first, err := s.docker("run -d -v tenant1/test:/mnt ubuntu sleep infinity")
+	c.Assert(err, IsNil)
+
+	second, err := s.docker("run -d -v tenant1/test:/mnt ubuntu sleep infinity")
+	c.Assert(err, IsNil)
+
+	// the volume stays mounted for the second container when the first stops,
+	// across a volplugin restart too.
+	_, err = s.docker("rm -f " + strings.TrimSpace(first))
+	c.Assert(err, IsNil)
+
+	c.Assert(stopVolplugin(s.vagrant.GetNode("mon0")), IsNil)
+	c.Assert(startVolplugin(s.vagrant.GetNode("mon0")), IsNil)
+
+	_, err = s.docker("exec " + strings.TrimSpace(second) + " touch /mnt/foo")
+	c.Assert(err, IsNil)
+
+	_, err = s.volcli("mount get tenant1 test")
+	c.Assert(err, IsNil)
+
+	_, err = s.docker("rm -f " + strings.TrimSpace(second))
+	c.Assert(err, IsNil)
+
+	_, err = s.volcli("mount get tenant1 test")
+	c.Assert(err, NotNil)
+}
+
+func (s *systemtestSuite) TestSharedReadOnly(c *C) {
+	c.Assert(s.createVolume("mon0", "tenant1", "test", nil), IsNil)
+	defer s.purgeVolume("mon0", "tenant1", "test", true)
+
+	_, err := s.volcli("volume set tenant1 test shared-readonly=true")
+	c.Assert(err, IsNil)
+
+	for _, name := range []string{"mon1", "mon2"} {
+		c.Assert(s.createVolume(name, "tenant1", "test", nil), IsNil)
+		defer s.purgeVolume(name, "tenant1", "test", false)
+	}
+
+	defer s.clearContainers()
+
+	dockerCmd := "docker run -d -v tenant1/test:/mnt ubuntu sleep infinity"
+	for _, nodeName := range []string{"mon0", "mon1", "mon2"} {
+		c.Assert(s.vagrant.GetNode(nodeName).RunCommand(dockerCmd), IsNil)
+	}
+
+	out, err := s.volcli("mount get tenant1 test")
+	c.Assert(err, IsNil)
+
+	holders := []*config.MountConfig{}
+	c.Assert(json.Unmarshal([]byte(out), &holders), IsNil)
+	c.Assert(holders, HasLen, 3)
+
+	for _, mt := range holders {
+		c.Assert(mt.ReadOnly, Equals, true)
+	}
+
+	_, err = s.docker("run --rm -v tenant1/test:/mnt ubuntu touch /mnt/foo")
+	c.Assert(err, NotNil)
+
+	// read-write mounts are refused while read-only holders exist.
+	c.Assert(s.clearContainers(), IsNil)
+	c.Assert(s.vagrant.GetNode("mon0").RunCommand(dockerCmd), IsNil)
+
+	_, err = s.volcli("volume set tenant1 test shared-readonly=false")
+	c.Assert(err, IsNil)
+
+	_, err = s.vagrant.GetNode("mon1").RunCommandWithOutput(dockerCmd)
+	c.Assert(err, NotNil)
+}
+
 func (s *systemtestSuite) TestMultiPool(c *C) {
 	_, err := s.mon0cmd("sudo ceph osd pool create test 1 1")
 	c.Assert(err, IsNil)
@@ -182,6 +258,31 @@ func (s *systemtestSuite) TestMultipleFileSystems(c *C) {
 	c.Assert(pass, Equals, true)
 }
 
+func (s *systemtestSuite) TestCreateRollback(c *C) {
+	_, err := s.uploadIntent("tenant2", "badfs")
+	c.Assert(err, IsNil)
+
+	out, err := s.vagrant.GetNode("mon0").RunCommandWithOutput("docker volume create -d volplugin --name tenant2/test")
+	c.Assert(err, NotNil)
+	c.Assert(out, Matches, "(?s).*Error creating filesystem.*")
+
+	// neither the record nor the image are left behind.
+	_, err = s.volcli("volume get tenant2 test")
+	c.Assert(err, NotNil)
+
+	out, err = s.vagrant.GetNode("mon0").RunCommandWithOutput("sudo rbd ls rbd")
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(out, "tenant2.test"), Equals, false)
+
+	// so the create can be retried.
+	c.Assert(s.createVolume("mon0", "tenant2", "test", map[string]string{"filesystem": "ext4"}), IsNil)
+	defer s.purgeVolume("mon0", "tenant2", "test", true)
+
+	out, err = s.volcli("volume list --state tenant2")
+	c.Assert(err, IsNil)
+	c.Assert(strings.TrimSpace(out), Equals, "test\tready")
+}
+
 func (s *systemtestSuite) TestMultiTenantVolumeCreate(c *C) {
 	_, err := s.uploadIntent("tenant2", "intent2")
 	c.Assert(err, IsNil)
@@ -283,3 +384,25 @@ func (s *systemtestSuite) TestRateLimiting(c *C) {
 		c.Assert(found, Equals, true)
 	}
 }
+
+func (s *systemtestSuite) TestQuota(c *C) {
+	_, err := s.uploadIntent("quota", "quota")
+	c.Assert(err, IsNil)
+	defer s.volcli("tenant delete quota")
+
+	defer s.purgeVolume("mon0", "quota", "test", true)
+
+	out, err := s.mon0cmd("docker volume create -d volplugin --name quota/big --opt size=30")
+	c.Assert(err, NotNil)
+	c.Assert(out, Matches, `(?s).*Quota exceeded for tenant "quota".*`)
+
+	c.Assert(s.createVolume("mon0", "quota", "test", nil), IsNil)
+
+	out, err = s.mon0cmd("docker volume create -d volplugin --name quota/test2")
+	c.Assert(err, NotNil)
+	c.Assert(out, Matches, `(?s).*Quota exceeded for tenant "quota": 1 of 1 volumes are in use.*`)
+
+	out, err = s.volcli("tenant usage quota")
+	c.Assert(err, IsNil)
+	c.Assert(out, Matches, `(?s)volumes: +1 of 1\n.*`)
+}
@@ -2,6 +2,7 @@ package systemtests
 
 import (
 	"encoding/json"
+	"fmt"
 	"strings"
 
 	. "gopkg.in/check.v1"
@@ -41,6 +42,21 @@ func (s *systemtestSuite) TestVolCLITenant(c *C) {
 	out, err := s.volcli("tenant get test1")
 	c.Assert(err, IsNil)
 
+	revision := struct {
+		Revision uint64 `json:"revision"`
+	}{}
+	c.Assert(json.Unmarshal([]byte(out), &revision), IsNil)
+	c.Assert(revision.Revision, Not(Equals), uint64(0))
+
+	// a stale revision is refused; without a terminal, there is no retry.
+	_, err = s.volcli(fmt.Sprintf("tenant upload --if-revision %d test1 < /testdata/intent2.json", revision.Revision+1))
+	c.Assert(err, NotNil)
+	_, err = s.volcli(fmt.Sprintf("tenant upload --if-revision %d test1 < /testdata/intent1.json", revision.Revision))
+	c.Assert(err, IsNil)
+
+	out, err = s.volcli("tenant get test1")
+	c.Assert(err, IsNil)
+
 	intentTarget := &config.TenantConfig{}
 	c.Assert(json.Unmarshal([]byte(out), intentTarget), IsNil)
 	intent1.FileSystems = map[string]string{"ext4": "mkfs.ext4 -m0 %"}
@@ -66,6 +82,52 @@ func (s *systemtestSuite) TestVolCLITenant(c *C) {
 	c.Assert(out, Matches, ".*test2.*")
 }
 
+func (s *systemtestSuite) TestVolCLITenantCascade(c *C) {
+	_, err := s.uploadIntent("cascade", "intent1")
+	c.Assert(err, IsNil)
+
+	defer s.volcli("tenant delete --cascade cascade")
+
+	for _, name := range []string{"foo", "bar"} {
+		defer s.purgeVolume("mon0", "cascade", name, true)
+		_, err = s.volcli("volume create cascade " + name)
+		c.Assert(err, IsNil)
+	}
+
+	// a tenant with volumes is kept unless they are removed with it.
+	out, err := s.volcli("tenant delete cascade")
+	c.Assert(err, NotNil)
+	c.Assert(out, Matches, `(?s).*Tenant "cascade" still has 2 volume\(s\).*`)
+
+	id, err := s.docker("run -itd -v cascade/foo:/mnt ubuntu sleep infinity")
+	c.Assert(err, IsNil)
+
+	// nothing is removed while a volume is mounted.
+	out, err = s.volcli("tenant delete --cascade cascade")
+	c.Assert(err, NotNil)
+	c.Assert(out, Matches, `(?s).*mounted volumes.*foo.*`)
+
+	out, err = s.volcli("volume list cascade")
+	c.Assert(err, IsNil)
+	c.Assert(strings.Fields(out), HasLen, 2)
+
+	_, err = s.docker("rm -f " + id)
+	c.Assert(err, IsNil)
+	_, err = s.docker("volume rm cascade/foo")
+	c.Assert(err, IsNil)
+
+	out, err = s.volcli("tenant delete --cascade cascade")
+	c.Assert(err, IsNil)
+	c.Assert(out, Matches, `(?s)Removing volume "bar" \(1/2\)\.\.\. done.*Removing volume "foo" \(2/2\)\.\.\. done.*`)
+
+	_, err = s.volcli("tenant get cascade")
+	c.Assert(err, NotNil)
+
+	out, err = s.mon0cmd("sudo rbd ls rbd")
+	c.Assert(err, IsNil)
+	c.Assert(out, Not(Matches), `(?s).*cascade\..*`)
+}
+
 func (s *systemtestSuite) TestVolCLIVolume(c *C) {
 	// XXX note that this is removed as a standard part of the tests and may error,
 	// so we don't check it.
@@ -84,6 +146,10 @@ func (s *systemtestSuite) TestVolCLIVolume(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(strings.TrimSpace(out), Equals, "tenant1/foo")
 
+	out, err = s.volcli("volume list-all --state")
+	c.Assert(err, IsNil)
+	c.Assert(strings.TrimSpace(out), Equals, "tenant1/foo\tready")
+
 	out, err = s.volcli("volume get tenant1 foo")
 	c.Assert(err, IsNil)
 
@@ -140,19 +206,23 @@ func (s *systemtestSuite) TestVolCLIMount(c *C) {
 
 	out, err := s.volcli("mount list")
 	c.Assert(err, IsNil)
-	c.Assert(strings.TrimSpace(out), Equals, "rbd/foo")
+	c.Assert(strings.TrimSpace(out), Equals, "tenant1/foo")
 
-	out, err = s.volcli("mount get rbd foo")
+	out, err = s.volcli("mount get tenant1 foo")
 	c.Assert(err, IsNil)
 
-	mt := &config.MountConfig{}
-	c.Assert(json.Unmarshal([]byte(out), mt), IsNil)
+	holders := []*config.MountConfig{}
+	c.Assert(json.Unmarshal([]byte(out), &holders), IsNil)
+	c.Assert(holders, HasLen, 1)
+
+	mt := holders[0]
+	c.Assert(mt.Tenant, Equals, "tenant1")
 	c.Assert(mt.Volume, Equals, "foo")
 	c.Assert(mt.Pool, Equals, "rbd")
 	c.Assert(mt.Host, Equals, "ceph-mon0")
 	c.Assert(mt.MountPoint, Equals, "/mnt/ceph/rbd/tenant1.foo")
 
-	_, err = s.volcli("mount force-remove rbd foo")
+	_, err = s.volcli("mount force-remove tenant1 foo")
 	c.Assert(err, IsNil)
 
 	out, err = s.volcli("mount list")
@@ -176,3 +246,63 @@ func (s *systemtestSuite) TestVolCLIMount(c *C) {
 	// instead, which would happen above.
 	c.Assert(out, Equals, "")
 }
+
+func (s *systemtestSuite) TestVolCLIVolumeAdopt(c *C) {
+	defer s.mon0cmd("sudo rbd rm rbd/legacy")
+	defer s.volcli("volume remove tenant1 adopted")
+
+	out, err := s.mon0cmd("sudo rbd create legacy --size 20 --pool rbd && sudo sh -c 'dev=$(rbd map legacy --pool rbd) && mkfs.ext4 -m0 $dev && rbd unmap $dev'")
+	c.Assert(err, IsNil, Commentf(out))
+
+	_, err = s.volcli("volume adopt tenant1 adopted --pool rbd --image legacy")
+	c.Assert(err, IsNil)
+
+	out, err = s.volcli("volume get tenant1 adopted")
+	c.Assert(err, IsNil)
+
+	cfg := &config.VolumeConfig{}
+	c.Assert(json.Unmarshal([]byte(out), cfg), IsNil)
+	c.Assert(cfg.ImageName(), Equals, "legacy")
+	c.Assert(cfg.Options.Size, Equals, uint64(20))
+	c.Assert(cfg.Options.FileSystem, Equals, "ext4")
+
+	_, err = s.docker("run --rm -v tenant1/adopted:/mnt ubuntu ls")
+	c.Assert(err, IsNil)
+
+	_, err = s.volcli("volume adopt tenant1 adopted --pool rbd --image legacy")
+	c.Assert(err, NotNil)
+}
+
+func (s *systemtestSuite) TestVolCLIVolumeSet(c *C) {
+	defer s.volcli("volume remove tenant1 foo")
+
+	_, err := s.volcli("volume create tenant1 foo")
+	c.Assert(err, IsNil)
+
+	_, err = s.volcli("volume set tenant1 foo rate-limit.write.iops=500 snapshots.keep=5")
+	c.Assert(err, IsNil)
+
+	out, err := s.volcli("volume get tenant1 foo")
+	c.Assert(err, IsNil)
+
+	cfg := &config.VolumeConfig{}
+	c.Assert(json.Unmarshal([]byte(out), cfg), IsNil)
+	c.Assert(cfg.Options.RateLimit.WriteIOPS, Equals, uint(500))
+	c.Assert(cfg.Options.Snapshot.Keep, Equals, uint(5))
+
+	_, err = s.volcli("volume set tenant1 foo pool=other")
+	c.Assert(err, NotNil)
+
+	_, err = s.volcli("volume set tenant1 foo")
+	c.Assert(err, NotNil)
+}
+
+func (s *systemtestSuite) TestVolCLIOptions(c *C) {
+	out, err := s.volcli("options --tenant tenant1")
+	c.Assert(err, IsNil)
+	c.Assert(out, Matches, `(?s).*snapshots\.keep +integer +20 .*`)
+
+	out, err = s.mon0cmd("docker volume create -d volplugin --name tenant1/test --opt snapshot.keep=10")
+	c.Assert(err, NotNil)
+	c.Assert(out, Matches, `(?s).*did you mean "snapshots\.keep".*`)
+}
@@ -1,14 +1,19 @@
 package volcli
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"sort"
 	"strings"
+	"text/tabwriter"
 
 	"github.com/codegangsta/cli"
 	"github.com/contiv/volplugin/config"
@@ -22,6 +27,107 @@ func errExit(ctx *cli.Context, err error, help bool) {
 	os.Exit(1)
 }
 
+func newConfig(ctx *cli.Context) (*config.TopLevelConfig, error) {
+	store, err := config.NewStore(ctx.String("etcd-api"), ctx.StringSlice("etcd"))
+	if err != nil {
+		return nil, err
+	}
+
+	return config.NewTopLevelConfigWithStore(ctx.String("prefix"), store), nil
+}
+
+// confirm asks a yes/no question on the terminal, defaulting to no.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	return readYes(os.Stdin)
+}
+
+// confirmTerminal is like confirm, but asks on the controlling terminal, for
+// commands which read their input from stdin. Without a terminal, the answer
+// is no.
+func confirmTerminal(question string) bool {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(tty, "%s [y/N] ", question)
+	return readYes(tty)
+}
+
+func readYes(r io.Reader) bool {
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+
+	return false
+}
+
+// retryConflicts runs op against the revision given with --if-revision, or
+// zero for none. If op fails with ErrConflict, the user is shown the current
+// revision of the record, which current returns, and asked whether to retry
+// against it.
+func retryConflicts(ctx *cli.Context, what string, current func() (uint64, error), op func(revision uint64) error) error {
+	if ctx.Int("if-revision") < 0 {
+		return fmt.Errorf("Invalid revision %d", ctx.Int("if-revision"))
+	}
+
+	revision := uint64(ctx.Int("if-revision"))
+
+	for {
+		err := op(revision)
+		if err != config.ErrConflict {
+			return err
+		}
+
+		latest, err := current()
+		if err != nil {
+			return err
+		}
+
+		if !confirmTerminal(fmt.Sprintf("%s was modified and is now at revision %d. Retry against revision %d?", what, latest, latest)) {
+			return config.ErrConflict
+		}
+
+		revision = latest
+	}
+}
+
+// postRevisioned posts a request to the volmaster and returns the response
+// body. Revision conflicts are returned as ErrConflict.
+func postRevisioned(ctx *cli.Context, path string, req interface{}) ([]byte, error) {
+	content, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create request JSON: %v", err)
+	}
+
+	resp, err := http.Post(fmt.Sprintf("http://%s/%s", ctx.String("master"), path), "application/json", bytes.NewBuffer(content))
+	if err != nil {
+		return nil, err
+	}
+
+	content, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return content, nil
+	case http.StatusConflict:
+		return nil, config.ErrConflict
+	default:
+		return nil, fmt.Errorf("Response Status Code was %d, not 200: %s", resp.StatusCode, strings.TrimSpace(string(content)))
+	}
+}
+
 func ppJSON(v interface{}) ([]byte, error) {
 	return json.MarshalIndent(v, "", "  ")
 }
@@ -32,7 +138,7 @@ func TenantUpload(ctx *cli.Context) {
 		errExit(ctx, fmt.Errorf("Invalid arguments"), true)
 	}
 
-	cfg, err := config.NewTopLevelConfig(ctx.String("prefix"), ctx.StringSlice("etcd"))
+	cfg, err := newConfig(ctx)
 	if err != nil {
 		errExit(ctx, err, false)
 	}
@@ -48,8 +154,48 @@ func TenantUpload(ctx *cli.Context) {
 		errExit(ctx, err, false)
 	}
 
-	if err := cfg.PublishTenant(ctx.Args()[0], tenant); err != nil {
-		errExit(ctx, err, false)
+	name := ctx.Args()[0]
+
+	if ctx.Int("if-revision") < 0 {
+		errExit(ctx, fmt.Errorf("Invalid revision %d", ctx.Int("if-revision")), false)
+	}
+
+	// the upload replaces the whole record, so retrying it against a newer
+	// revision would silently undo the changes which made that revision.
+	tenant.Revision = uint64(ctx.Int("if-revision"))
+	if err := cfg.PublishTenant(name, tenant); err != nil {
+		if err != config.ErrConflict {
+			errExit(ctx, err, false)
+		}
+
+		latest, err := tenantRevision(cfg, name)()
+		if err != nil {
+			errExit(ctx, err, false)
+		}
+
+		errExit(ctx, fmt.Errorf("Tenant %q was modified and is now at revision %d; merge your changes into `volcli tenant get %s` and upload again", name, latest, name), false)
+	}
+}
+
+func tenantRevision(cfg *config.TopLevelConfig, name string) func() (uint64, error) {
+	return func() (uint64, error) {
+		tc, err := cfg.GetTenant(name)
+		if err != nil {
+			return 0, err
+		}
+
+		return tc.Revision, nil
+	}
+}
+
+func volumeRevision(cfg *config.TopLevelConfig, tenant, name string) func() (uint64, error) {
+	return func() (uint64, error) {
+		vc, err := cfg.GetVolume(tenant, name)
+		if err != nil {
+			return 0, err
+		}
+
+		return vc.Revision, nil
 	}
 }
 
@@ -61,18 +207,89 @@ func TenantDelete(ctx *cli.Context) {
 
 	tenant := ctx.Args()[0]
 
-	cfg, err := config.NewTopLevelConfig(ctx.String("prefix"), ctx.StringSlice("etcd"))
+	cfg, err := newConfig(ctx)
 	if err != nil {
 		errExit(ctx, err, false)
 	}
 
-	if err := cfg.DeleteTenant(tenant); err != nil {
+	if ctx.Bool("cascade") {
+		if err := removeTenantVolumes(ctx, cfg, tenant); err != nil {
+			errExit(ctx, err, false)
+		}
+	}
+
+	err = retryConflicts(ctx, fmt.Sprintf("Tenant %q", tenant), tenantRevision(cfg, tenant), func(revision uint64) error {
+		return cfg.DeleteTenant(tenant, revision)
+	})
+	if err != nil {
 		errExit(ctx, err, false)
 	}
 
 	fmt.Printf("%q removed!\n", tenant)
 }
 
+// removeTenantVolumes removes every volume of a tenant through the volmaster,
+// images included, printing its progress. Nothing is removed while any host
+// holds a mount of the volumes, or while other tenants extend the tenant, as
+// it could not be deleted afterwards.
+func removeTenantVolumes(ctx *cli.Context, cfg *config.TopLevelConfig, tenant string) error {
+	dependents, err := cfg.TenantDependents(tenant)
+	if err != nil {
+		return err
+	}
+
+	if len(dependents) > 0 {
+		return fmt.Errorf("Tenant %q is extended by tenant(s) %s; change or remove them first", tenant, strings.Join(dependents, ", "))
+	}
+
+	vols, err := cfg.ListVolumes(tenant)
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	mounted := []string{}
+
+	for name := range vols {
+		names = append(names, name)
+
+		holders, err := cfg.MountHolders(tenant, name)
+		if err != nil {
+			return err
+		}
+
+		if len(holders) > 0 {
+			mounted = append(mounted, name)
+		}
+	}
+
+	sort.Strings(names)
+	sort.Strings(mounted)
+
+	if len(mounted) > 0 {
+		return fmt.Errorf("Tenant %q has mounted volumes, which must be unmounted first: %s", tenant, strings.Join(mounted, ", "))
+	}
+
+	failed := 0
+
+	for i, name := range names {
+		fmt.Printf("Removing volume %q (%d/%d)... ", name, i+1, len(names))
+		if _, err := postRevisioned(ctx, "remove", &config.Request{Tenant: tenant, Volume: name}); err != nil {
+			fmt.Printf("failed: %v\n", err)
+			failed++
+			continue
+		}
+
+		fmt.Println("done")
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d volumes of tenant %q could not be removed; the tenant was kept", failed, len(names), tenant)
+	}
+
+	return nil
+}
+
 // TenantGet retrieves tenant configuration, the name of which is supplied as
 // an argument.
 func TenantGet(ctx *cli.Context) {
@@ -82,17 +299,48 @@ func TenantGet(ctx *cli.Context) {
 
 	tenant := ctx.Args()[0]
 
-	cfg, err := config.NewTopLevelConfig(ctx.String("prefix"), ctx.StringSlice("etcd"))
+	cfg, err := newConfig(ctx)
 	if err != nil {
 		errExit(ctx, err, false)
 	}
 
+	if ctx.Bool("resolved") {
+		resolved, sources, err := cfg.ResolveTenant(tenant)
+		if err != nil {
+			errExit(ctx, err, false)
+		}
+
+		content, err := ppJSON(map[string]interface{}{"config": resolved, "sources": sources})
+		if err != nil {
+			errExit(ctx, err, false)
+		}
+
+		fmt.Println(string(content))
+		return
+	}
+
 	value, err := cfg.GetTenant(tenant)
 	if err != nil {
 		errExit(ctx, err, false)
 	}
 
-	content, err := ppJSON(value)
+	content, err := json.Marshal(value)
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	// UseNumber keeps large integers such as rate limits intact.
+	record := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&record); err != nil {
+		errExit(ctx, err, false)
+	}
+
+	// the revision is shown for --if-revision; uploads ignore it.
+	record["revision"] = value.Revision
+
+	content, err = ppJSON(record)
 	if err != nil {
 		errExit(ctx, err, false)
 	}
@@ -100,13 +348,219 @@ func TenantGet(ctx *cli.Context) {
 	fmt.Println(string(content))
 }
 
+// TenantApply re-merges fields of a tenant's default options into its
+// existing volumes, after showing the changes.
+func TenantApply(ctx *cli.Context) {
+	if len(ctx.Args()) != 1 {
+		errExit(ctx, fmt.Errorf("Invalid arguments"), true)
+	}
+
+	if ctx.String("fields") == "" {
+		errExit(ctx, fmt.Errorf("--fields is required"), true)
+	}
+
+	cfg, err := newConfig(ctx)
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	plan, err := cfg.PlanTenantApply(ctx.Args()[0], strings.Split(ctx.String("fields"), ","))
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	if len(plan) == 0 {
+		fmt.Println("All volumes are up to date.")
+		return
+	}
+
+	for _, change := range plan {
+		if change.Skipped != "" {
+			fmt.Printf("%s (skipped: %s):\n", change.Volume, change.Skipped)
+		} else {
+			fmt.Printf("%s:\n", change.Volume)
+		}
+
+		for _, opt := range change.Changes {
+			fmt.Printf("  %s: %q -> %q\n", opt.Key, opt.Old, opt.New)
+		}
+	}
+
+	if ctx.Bool("dry-run") {
+		return
+	}
+
+	if !ctx.Bool("yes") && !confirm("Apply these changes?") {
+		errExit(ctx, fmt.Errorf("Aborted"), false)
+	}
+
+	failed := cfg.ApplyVolumeChanges(plan)
+
+	for _, change := range plan {
+		switch {
+		case change.Skipped != "":
+			fmt.Printf("%s: skipped\n", change.Volume)
+		case failed[change.Volume] != nil:
+			fmt.Printf("%s: failed: %v\n", change.Volume, failed[change.Volume])
+		default:
+			fmt.Printf("%s: updated\n", change.Volume)
+		}
+	}
+
+	if len(failed) > 0 {
+		errExit(ctx, fmt.Errorf("%d volume(s) could not be updated", len(failed)), false)
+	}
+}
+
+// TenantProfiles lists the profiles of a tenant, including the inherited ones,
+// and their options.
+func TenantProfiles(ctx *cli.Context) {
+	if len(ctx.Args()) != 1 {
+		errExit(ctx, fmt.Errorf("Invalid arguments"), true)
+	}
+
+	cfg, err := newConfig(ctx)
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	tenant, _, err := cfg.ResolveTenant(ctx.Args()[0])
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	for _, name := range tenant.ProfileNames() {
+		fmt.Println(name)
+
+		keys := []string{}
+		for key := range tenant.Profiles[name] {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fmt.Printf("  %s=%s\n", key, tenant.Profiles[name][key])
+		}
+	}
+}
+
+// TenantUsage reports what the volumes of a tenant consume against its
+// effective quota.
+func TenantUsage(ctx *cli.Context) {
+	if len(ctx.Args()) != 1 {
+		errExit(ctx, fmt.Errorf("Invalid arguments"), true)
+	}
+
+	cfg, err := newConfig(ctx)
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	tenant, _, err := cfg.ResolveTenant(ctx.Args()[0])
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	usage, err := cfg.TenantUsage(ctx.Args()[0])
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	quota := tenant.Quota
+	if quota == nil {
+		quota = &config.QuotaConfig{}
+	}
+
+	fmt.Printf("volumes:         %d of %s\n", usage.Volumes, limit(uint64(quota.MaxVolumes), ""))
+	fmt.Printf("total size:      %d MB of %s\n", usage.TotalSize, limit(quota.MaxTotalSize, " MB"))
+	fmt.Printf("max volume size: %s\n", limit(quota.MaxVolumeSize, " MB"))
+	fmt.Printf("max snapshots:   %s\n", limit(uint64(quota.MaxSnapshots), ""))
+}
+
+// limit formats a quota limit, where zero is unlimited.
+func limit(value uint64, unit string) string {
+	if value == 0 {
+		return "unlimited"
+	}
+
+	return fmt.Sprintf("%d%s", value, unit)
+}
+
+// GlobalUpload uploads the global defaults from stdin.
+func GlobalUpload(ctx *cli.Context) {
+	if len(ctx.Args()) != 0 {
+		errExit(ctx, fmt.Errorf("Invalid arguments"), true)
+	}
+
+	cfg, err := newConfig(ctx)
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	content, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	global := &config.TenantConfig{}
+
+	if err := json.Unmarshal(content, global); err != nil {
+		errExit(ctx, err, false)
+	}
+
+	if err := cfg.PublishGlobal(global); err != nil {
+		errExit(ctx, err, false)
+	}
+}
+
+// GlobalGet displays the global defaults.
+func GlobalGet(ctx *cli.Context) {
+	if len(ctx.Args()) != 0 {
+		errExit(ctx, fmt.Errorf("Invalid arguments"), true)
+	}
+
+	cfg, err := newConfig(ctx)
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	global, err := cfg.GetGlobal()
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	content, err := ppJSON(global)
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	fmt.Println(string(content))
+}
+
+// GlobalDelete removes the global defaults.
+func GlobalDelete(ctx *cli.Context) {
+	if len(ctx.Args()) != 0 {
+		errExit(ctx, fmt.Errorf("Invalid arguments"), true)
+	}
+
+	cfg, err := newConfig(ctx)
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	if err := cfg.DeleteGlobal(); err != nil {
+		errExit(ctx, err, false)
+	}
+}
+
 // TenantList provides a list of the tenant names.
 func TenantList(ctx *cli.Context) {
 	if len(ctx.Args()) != 0 {
 		errExit(ctx, fmt.Errorf("Invalid arguments"), true)
 	}
 
-	cfg, err := config.NewTopLevelConfig(ctx.String("prefix"), ctx.StringSlice("etcd"))
+	cfg, err := newConfig(ctx)
 	if err != nil {
 		errExit(ctx, err, false)
 	}
@@ -160,13 +614,165 @@ func VolumeCreate(ctx *cli.Context) {
 	}
 }
 
+// Options lists the options volumes accept, as reported by the volmaster.
+func Options(ctx *cli.Context) {
+	if len(ctx.Args()) != 0 {
+		errExit(ctx, fmt.Errorf("Invalid arguments"), true)
+	}
+
+	query := url.Values{}
+	if ctx.String("tenant") != "" {
+		query.Set("tenant", ctx.String("tenant"))
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/options?%s", ctx.String("master"), query.Encode()))
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	if resp.StatusCode != 200 {
+		errExit(ctx, fmt.Errorf("Response Status Code was %d, not 200: %s", resp.StatusCode, strings.TrimSpace(string(content))), false)
+	}
+
+	catalog := []config.OptionInfo{}
+	if err := json.Unmarshal(content, &catalog); err != nil {
+		errExit(ctx, err, false)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "KEY\tTYPE\tDEFAULT\tDESCRIPTION")
+	for _, info := range catalog {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", info.Key, info.Type, info.Default, info.Description)
+	}
+	writer.Flush()
+}
+
+// VolumeSet changes options of an existing volume through the volmaster.
+func VolumeSet(ctx *cli.Context) {
+	if len(ctx.Args()) < 3 {
+		errExit(ctx, fmt.Errorf("Invalid arguments"), true)
+	}
+
+	opts := map[string]string{}
+
+	for _, str := range ctx.Args()[2:] {
+		pair := strings.SplitN(str, "=", 2)
+		if len(pair) < 2 {
+			errExit(ctx, fmt.Errorf("Mismatched option pair %q", pair), false)
+		}
+
+		opts[pair[0]] = pair[1]
+	}
+
+	cfg, err := newConfig(ctx)
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	tenant, volume := ctx.Args()[0], ctx.Args()[1]
+
+	var content []byte
+
+	err = retryConflicts(ctx, fmt.Sprintf("Volume %q of tenant %q", volume, tenant), volumeRevision(cfg, tenant, volume), func(revision uint64) error {
+		var err error
+		content, err = postRevisioned(ctx, "update", &config.RequestUpdate{Tenant: tenant, Volume: volume, Opts: opts, Revision: revision})
+		return err
+	})
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	fmt.Println(string(content))
+}
+
+// VolumeAdopt brings a pre-existing RBD image under management as a volume
+// of the supplied tenant.
+func VolumeAdopt(ctx *cli.Context) {
+	if len(ctx.Args()) != 2 {
+		errExit(ctx, fmt.Errorf("Invalid arguments"), true)
+	}
+
+	if ctx.String("pool") == "" || ctx.String("image") == "" {
+		errExit(ctx, fmt.Errorf("--pool and --image are required"), true)
+	}
+
+	req := &config.RequestAdopt{
+		Tenant: ctx.Args()[0],
+		Volume: ctx.Args()[1],
+		Pool:   ctx.String("pool"),
+		Image:  ctx.String("image"),
+		Rename: ctx.Bool("rename"),
+	}
+
+	content, err := json.Marshal(req)
+	if err != nil {
+		errExit(ctx, fmt.Errorf("Could not create request JSON: %v", err), false)
+	}
+
+	resp, err := http.Post(fmt.Sprintf("http://%s/adopt", ctx.String("master")), "application/json", bytes.NewBuffer(content))
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	content, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	if resp.StatusCode != 200 {
+		errExit(ctx, fmt.Errorf("Response Status Code was %d, not 200: %s", resp.StatusCode, strings.TrimSpace(string(content))), false)
+	}
+
+	fmt.Println(string(content))
+}
+
+// VolumeMigrate moves a volume's image to another pool.
+func VolumeMigrate(ctx *cli.Context) {
+	if len(ctx.Args()) != 3 {
+		errExit(ctx, fmt.Errorf("Invalid arguments"), true)
+	}
+
+	req := &config.RequestMigrate{
+		Tenant:    ctx.Args()[0],
+		Volume:    ctx.Args()[1],
+		Pool:      ctx.Args()[2],
+		Snapshots: ctx.Bool("snapshots"),
+	}
+
+	content, err := json.Marshal(req)
+	if err != nil {
+		errExit(ctx, fmt.Errorf("Could not create request JSON: %v", err), false)
+	}
+
+	resp, err := http.Post(fmt.Sprintf("http://%s/migrate", ctx.String("master")), "application/json", bytes.NewBuffer(content))
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	content, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	if resp.StatusCode != 200 {
+		errExit(ctx, fmt.Errorf("Response Status Code was %d, not 200: %s", resp.StatusCode, strings.TrimSpace(string(content))), false)
+	}
+
+	fmt.Println(string(content))
+}
+
 // VolumeGet retrieves the metadata for a volume and prints it.
 func VolumeGet(ctx *cli.Context) {
 	if len(ctx.Args()) != 2 {
 		errExit(ctx, fmt.Errorf("Invalid arguments"), true)
 	}
 
-	cfg, err := config.NewTopLevelConfig(ctx.String("prefix"), ctx.StringSlice("etcd"))
+	cfg, err := newConfig(ctx)
 	if err != nil {
 		errExit(ctx, err, false)
 	}
@@ -176,7 +782,10 @@ func VolumeGet(ctx *cli.Context) {
 		errExit(ctx, err, false)
 	}
 
-	content, err := ppJSON(vol)
+	content, err := ppJSON(struct {
+		*config.VolumeConfig
+		Revision uint64 `json:"revision"`
+	}{vol, vol.Revision})
 	if err != nil {
 		errExit(ctx, err, false)
 	}
@@ -190,12 +799,17 @@ func VolumeForceRemove(ctx *cli.Context) {
 		errExit(ctx, fmt.Errorf("Invalid arguments"), true)
 	}
 
-	cfg, err := config.NewTopLevelConfig(ctx.String("prefix"), ctx.StringSlice("etcd"))
+	cfg, err := newConfig(ctx)
 	if err != nil {
 		errExit(ctx, err, false)
 	}
 
-	if err := cfg.RemoveVolume(ctx.Args()[0], ctx.Args()[1]); err != nil {
+	tenant, volume := ctx.Args()[0], ctx.Args()[1]
+
+	err = retryConflicts(ctx, fmt.Sprintf("Volume %q of tenant %q", volume, tenant), volumeRevision(cfg, tenant, volume), func(revision uint64) error {
+		return cfg.RemoveVolume(tenant, volume, revision)
+	})
+	if err != nil {
 		errExit(ctx, err, false)
 	}
 }
@@ -206,17 +820,18 @@ func VolumeRemove(ctx *cli.Context) {
 		errExit(ctx, fmt.Errorf("Invalid arguments"), true)
 	}
 
-	request := config.Request{
-		Tenant: ctx.Args()[0],
-		Volume: ctx.Args()[1],
-	}
-
-	content, err := json.Marshal(request)
+	cfg, err := newConfig(ctx)
 	if err != nil {
 		errExit(ctx, err, false)
 	}
 
-	if _, err := http.Post(fmt.Sprintf("http://%s/remove", ctx.String("master")), "application/json", bytes.NewBuffer(content)); err != nil {
+	tenant, volume := ctx.Args()[0], ctx.Args()[1]
+
+	err = retryConflicts(ctx, fmt.Sprintf("Volume %q of tenant %q", volume, tenant), volumeRevision(cfg, tenant, volume), func(revision uint64) error {
+		_, err := postRevisioned(ctx, "remove", &config.Request{Tenant: tenant, Volume: volume, Revision: revision})
+		return err
+	})
+	if err != nil {
 		errExit(ctx, err, false)
 	}
 }
@@ -227,7 +842,7 @@ func VolumeList(ctx *cli.Context) {
 		errExit(ctx, fmt.Errorf("Invalid arguments"), true)
 	}
 
-	cfg, err := config.NewTopLevelConfig(ctx.String("prefix"), ctx.StringSlice("etcd"))
+	cfg, err := newConfig(ctx)
 	if err != nil {
 		errExit(ctx, err, false)
 	}
@@ -237,14 +852,18 @@ func VolumeList(ctx *cli.Context) {
 		errExit(ctx, err, false)
 	}
 
-	for name := range vols {
-		fmt.Println(name)
+	for name, vc := range vols {
+		if ctx.Bool("state") {
+			printVolumeState(name, vc)
+		} else {
+			fmt.Println(name)
+		}
 	}
 }
 
 // VolumeListAll returns a list of the pools the volmaster knows about.
 func VolumeListAll(ctx *cli.Context) {
-	cfg, err := config.NewTopLevelConfig(ctx.String("prefix"), ctx.StringSlice("etcd"))
+	cfg, err := newConfig(ctx)
 	if err != nil {
 		errExit(ctx, err, false)
 	}
@@ -255,13 +874,35 @@ func VolumeListAll(ctx *cli.Context) {
 	}
 
 	for _, name := range pools {
-		fmt.Println(name)
+		if !ctx.Bool("state") {
+			fmt.Println(name)
+			continue
+		}
+
+		parts := strings.SplitN(name, "/", 2)
+		vc, err := cfg.GetVolume(parts[0], parts[1])
+		if err != nil {
+			errExit(ctx, err, false)
+		}
+
+		printVolumeState(name, vc)
+	}
+}
+
+// printVolumeState prints the name and state of a volume, tab-separated,
+// followed by the error of failed volumes.
+func printVolumeState(name string, vc *config.VolumeConfig) {
+	if vc.State == config.StateFailed {
+		fmt.Printf("%s\t%s\t%s\n", name, vc.State, vc.StateError)
+		return
 	}
+
+	fmt.Printf("%s\t%s\n", name, vc.State)
 }
 
 // MountList returns a list of the mounts the volmaster knows about.
 func MountList(ctx *cli.Context) {
-	cfg, err := config.NewTopLevelConfig(ctx.String("prefix"), ctx.StringSlice("etcd"))
+	cfg, err := newConfig(ctx)
 	if err != nil {
 		errExit(ctx, err, false)
 	}
@@ -276,23 +917,28 @@ func MountList(ctx *cli.Context) {
 	}
 }
 
-// MountGet retrieves the JSON information for a mount.
+// MountGet retrieves the JSON information for the mounts of a volume: its
+// read-write mount, or each of its read-only holders.
 func MountGet(ctx *cli.Context) {
 	if len(ctx.Args()) != 2 {
 		errExit(ctx, fmt.Errorf("Invalid arguments"), true)
 	}
 
-	cfg, err := config.NewTopLevelConfig(ctx.String("prefix"), ctx.StringSlice("etcd"))
+	cfg, err := newConfig(ctx)
 	if err != nil {
 		errExit(ctx, err, false)
 	}
 
-	mount, err := cfg.GetMount(ctx.Args()[0], ctx.Args()[1])
+	holders, err := cfg.MountHolders(ctx.Args()[0], ctx.Args()[1])
 	if err != nil {
 		errExit(ctx, err, false)
 	}
 
-	content, err := ppJSON(mount)
+	if len(holders) == 0 {
+		errExit(ctx, config.ErrNotExist, false)
+	}
+
+	content, err := ppJSON(holders)
 	if err != nil {
 		errExit(ctx, err, false)
 	}
@@ -300,19 +946,89 @@ func MountGet(ctx *cli.Context) {
 	fmt.Println(string(content))
 }
 
-// MountForceRemove deletes the mount entry from etcd; useful for clearing a
-// stale mount.
+// MountForceRemove deletes the mount entries of a volume from etcd; useful for
+// clearing a stale mount.
 func MountForceRemove(ctx *cli.Context) {
 	if len(ctx.Args()) != 2 {
 		errExit(ctx, fmt.Errorf("Invalid arguments"), true)
 	}
 
-	cfg, err := config.NewTopLevelConfig(ctx.String("prefix"), ctx.StringSlice("etcd"))
+	cfg, err := newConfig(ctx)
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	holders, err := cfg.MountHolders(ctx.Args()[0], ctx.Args()[1])
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	if len(holders) == 0 {
+		errExit(ctx, config.ErrNotExist, false)
+	}
+
+	for _, mt := range holders {
+		if err := cfg.RemoveMount(mt, true); err != nil {
+			errExit(ctx, err, false)
+		}
+	}
+}
+
+// AdminMigrateEtcdV3 copies the configuration tree from the etcd v2 keys API
+// into the etcd v3 KV API. Keys present in v3 already are left alone.
+func AdminMigrateEtcdV3(ctx *cli.Context) {
+	if len(ctx.Args()) != 0 {
+		errExit(ctx, fmt.Errorf("Invalid arguments"), true)
+	}
+
+	from, err := config.NewStore("v2", ctx.StringSlice("etcd"))
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	to, err := config.NewStore("v3", ctx.StringSlice("etcd"))
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+
+	copied, skipped, err := config.CopyTree(ctx.String("prefix"), from, to)
+
+	for _, key := range copied {
+		fmt.Printf("copied %s\n", key)
+	}
+
+	for _, key := range skipped {
+		fmt.Printf("skipped %s: already exists in v3\n", key)
+	}
+
+	if err != nil {
+		errExit(ctx, err, false)
+	}
+}
+
+// AdminMigrate upgrades stored records written by older releases to the
+// current schema version.
+func AdminMigrate(ctx *cli.Context) {
+	if len(ctx.Args()) != 0 {
+		errExit(ctx, fmt.Errorf("Invalid arguments"), true)
+	}
+
+	cfg, err := newConfig(ctx)
 	if err != nil {
 		errExit(ctx, err, false)
 	}
 
-	if err := cfg.RemoveMount(&config.MountConfig{Pool: ctx.Args()[0], Volume: ctx.Args()[1]}, true); err != nil {
+	migrations, err := cfg.MigrateRecords(ctx.Bool("dry-run"))
+
+	for _, migration := range migrations {
+		if ctx.Bool("dry-run") {
+			fmt.Printf("would migrate %s\n", migration)
+		} else {
+			fmt.Printf("migrated %s\n", migration)
+		}
+	}
+
+	if err != nil {
 		errExit(ctx, err, false)
 	}
 }
@@ -26,6 +26,18 @@ var flags = []cli.Flag{
 		Usage: "URL for etcd",
 		Value: &cli.StringSlice{"http://localhost:2379"},
 	},
+	cli.StringFlag{
+		Name:  "etcd-api",
+		Usage: "etcd API version to use: v2 or v3",
+		Value: "v2",
+	},
+}
+
+// revisionFlag makes a change conditional on the revision of the record, as
+// shown by the get commands.
+var revisionFlag = cli.IntFlag{
+	Name:  "if-revision",
+	Usage: "only change the record if it is still at this revision",
 }
 
 func main() {
@@ -43,28 +55,71 @@ func main() {
 			Subcommands: []cli.Command{
 				{
 					Name:        "upload",
-					Flags:       flags,
+					Flags:       append(flags, revisionFlag),
 					ArgsUsage:   "[tenant name]. accepts from stdin",
-					Description: "Uploads a tenant to etcd. Accepts JSON for the tenant policy. Requires direct, unauthenticated access to etcd.",
+					Description: "Uploads a tenant to etcd. Accepts JSON for the tenant policy. Requires direct, unauthenticated access to etcd. With --if-revision, the upload fails if the tenant was changed since that revision.",
 					Usage:       "Upload a tenant to etcd",
 					Action:      volcli.TenantUpload,
 				},
 				{
-					Name:        "delete",
-					Flags:       flags,
+					Name: "delete",
+					Flags: append(flags, append(volmasterFlags, revisionFlag, cli.BoolFlag{
+						Name:  "cascade",
+						Usage: "Remove the volumes of the tenant and their images first",
+					})...),
 					ArgsUsage:   "[tenant name]",
-					Description: "Permanently removes a tenant from etcd. Volumes that belong to the tenant are unaffected.",
+					Description: "Permanently removes a tenant from etcd. This is refused while the tenant has volumes, unless --cascade is given to remove them through the volmaster first. Mounted volumes are never removed.",
 					Usage:       "Delete a tenant",
 					Action:      volcli.TenantDelete,
 				},
 				{
-					Name:        "get",
-					Flags:       flags,
+					Name: "get",
+					Flags: append(flags, cli.BoolFlag{
+						Name:  "resolved",
+						Usage: "Show the effective policy after inheritance, and the layer each value came from",
+					}),
 					ArgsUsage:   "[tenant name]",
 					Usage:       "Obtain the policy for a tenant",
-					Description: "Gets the policy for a tenant from etcd.",
+					Description: "Gets the policy for a tenant from etcd. With --resolved, the global defaults and the tenants it extends are applied, and the layer each value came from is listed.",
 					Action:      volcli.TenantGet,
 				},
+				{
+					Name:        "profiles",
+					Flags:       flags,
+					ArgsUsage:   "[tenant name]",
+					Description: "Lists the profiles of a tenant and the options each one sets. Select a profile with `--opt profile=<name>` when creating a volume.",
+					Usage:       "List the profiles of a tenant",
+					Action:      volcli.TenantProfiles,
+				},
+				{
+					Name:        "usage",
+					Flags:       flags,
+					ArgsUsage:   "[tenant name]",
+					Description: "Reports the number and total size of the tenant's volumes against its quota.",
+					Usage:       "Show a tenant's usage against its quota",
+					Action:      volcli.TenantUsage,
+				},
+				{
+					Name: "apply",
+					Flags: append(flags,
+						cli.StringFlag{
+							Name:  "fields",
+							Usage: "Comma-separated option fields to apply, e.g. rate-limit,snapshot",
+						},
+						cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "Show the changes without applying them",
+						},
+						cli.BoolFlag{
+							Name:  "yes",
+							Usage: "Apply the changes without asking",
+						},
+					),
+					ArgsUsage:   "[tenant name]",
+					Description: "Re-merges the named fields of the tenant's default options into every existing volume of the tenant. Options which were set when a volume was created or updated are left alone. The changes are shown and confirmed before they are written.",
+					Usage:       "Apply tenant policy changes to existing volumes",
+					Action:      volcli.TenantApply,
+				},
 				{
 					Name:        "list",
 					Flags:       flags,
@@ -75,6 +130,36 @@ func main() {
 				},
 			},
 		},
+		{
+			Name:  "global",
+			Usage: "Manage the global defaults",
+			Subcommands: []cli.Command{
+				{
+					Name:        "upload",
+					Flags:       flags,
+					ArgsUsage:   "",
+					Description: "Uploads the global defaults, which every tenant inherits, as JSON from standard input. They use the same format as a tenant, but may leave out any value.",
+					Usage:       "Upload the global defaults",
+					Action:      volcli.GlobalUpload,
+				},
+				{
+					Name:        "get",
+					Flags:       flags,
+					ArgsUsage:   "",
+					Description: "Gets the global defaults from etcd.",
+					Usage:       "Obtain the global defaults",
+					Action:      volcli.GlobalGet,
+				},
+				{
+					Name:        "delete",
+					Flags:       flags,
+					ArgsUsage:   "",
+					Description: "Removes the global defaults. Tenants which rely on them for required values, such as the pool, can no longer create volumes.",
+					Usage:       "Delete the global defaults",
+					Action:      volcli.GlobalDelete,
+				},
+			},
+		},
 		{
 			Name:  "volume",
 			Usage: "Manage Volumes",
@@ -90,6 +175,46 @@ func main() {
 					Usage:       "Create a volume for a given tenant",
 					Action:      volcli.VolumeCreate,
 				},
+				{
+					Name:        "set",
+					Flags:       append(flags, append(volmasterFlags, revisionFlag)...),
+					ArgsUsage:   "[tenant name] [volume name] [key=value] ...",
+					Description: "Changes options of an existing volume, using the same keys as `docker volume create --opt`. The pool, filesystem and size cannot be changed. Options applied at mount time, such as rate limits, take effect the next time the volume is mounted.",
+					Usage:       "Change the options of a volume",
+					Action:      volcli.VolumeSet,
+				},
+				{
+					Name: "adopt",
+					Flags: append(flags, append(volmasterFlags,
+						cli.StringFlag{
+							Name:  "pool",
+							Usage: "Pool the existing image lives in",
+						},
+						cli.StringFlag{
+							Name:  "image",
+							Usage: "Name of the existing image",
+						},
+						cli.BoolFlag{
+							Name:  "rename",
+							Usage: "Rename the image to the tenant.volume convention instead of recording its name",
+						},
+					)...),
+					ArgsUsage:   "[tenant name] [volume name]",
+					Description: "Brings a pre-existing RBD image under management. The size and filesystem are read from the image.",
+					Usage:       "Adopt an existing image as a volume",
+					Action:      volcli.VolumeAdopt,
+				},
+				{
+					Name: "migrate",
+					Flags: append(flags, append(volmasterFlags, cli.BoolFlag{
+						Name:  "snapshots",
+						Usage: "Copy the volume's snapshots along with the image",
+					})...),
+					ArgsUsage:   "[tenant name] [volume name] [pool name]",
+					Description: "Copies the image of an unmounted volume into another pool, verifies the copy, switches the volume to the new pool and removes the source.",
+					Usage:       "Move a volume to another pool",
+					Action:      volcli.VolumeMigrate,
+				},
 				{
 					Name:        "get",
 					Flags:       flags,
@@ -99,19 +224,25 @@ func main() {
 					Action:      volcli.VolumeGet,
 				},
 				{
-					Name:        "list",
-					Flags:       flags,
+					Name: "list",
+					Flags: append(flags, cli.BoolFlag{
+						Name:  "state",
+						Usage: "Show the state of each volume, and the error of failed volumes",
+					}),
 					ArgsUsage:   "[tenant name]",
 					Description: "Given a tenant name, produces a newline-delimited list of volumes.",
 					Usage:       "List all volumes for a given tenant",
 					Action:      volcli.VolumeList,
 				},
 				{
-					Name:        "list-all",
+					Name: "list-all",
+					Flags: append(flags, cli.BoolFlag{
+						Name:  "state",
+						Usage: "Show the state of each volume, and the error of failed volumes",
+					}),
 					ArgsUsage:   "",
 					Description: "Produces a newline-delimited list of tenant/volume combinations.",
 					Usage:       "List all volumes across tenants",
-					Flags:       flags,
 					Action:      volcli.VolumeListAll,
 				},
 				{
@@ -119,7 +250,7 @@ func main() {
 					ArgsUsage:   "[tenant name] [volume name]",
 					Description: "Forcefully removes a volume without deleting or unmounting the underlying image",
 					Usage:       "Forcefully remove a volume without removing the underlying image",
-					Flags:       flags,
+					Flags:       append(flags, revisionFlag),
 					Action:      volcli.VolumeForceRemove,
 				},
 				{
@@ -127,11 +258,44 @@ func main() {
 					ArgsUsage:   "[tenant name] [volume name]",
 					Description: "Remove the volume for a tenant, deleting its contents.",
 					Usage:       "Remove a volume and its contents",
-					Flags:       append(flags, volmasterFlags...),
+					Flags:       append(flags, append(volmasterFlags, revisionFlag)...),
 					Action:      volcli.VolumeRemove,
 				},
 			},
 		},
+		{
+			Name: "options",
+			Flags: append(volmasterFlags, cli.StringFlag{
+				Name:  "tenant",
+				Usage: "Show the defaults of this tenant instead of the built-in ones",
+			}),
+			ArgsUsage:   "",
+			Description: "Lists every option accepted by `docker volume create --opt` and `volcli volume create --opt`, with its type, default and description.",
+			Usage:       "List the supported volume options",
+			Action:      volcli.Options,
+		},
+		{
+			Name:  "admin",
+			Usage: "Administer the configuration store",
+			Subcommands: []cli.Command{
+				{
+					Name:        "migrate-etcd-v3",
+					Flags:       flags,
+					ArgsUsage:   "",
+					Description: "Copies the configuration under the prefix from the etcd v2 keys API into the etcd v3 KV API. Keys which already exist in v3 are skipped. Run it once, then start the services with --etcd-api v3.",
+					Usage:       "Copy configuration from etcd v2 to etcd v3",
+					Action:      volcli.AdminMigrateEtcdV3,
+				},
+				{
+					Name:        "migrate",
+					Flags:       append(flags, cli.BoolFlag{Name: "dry-run", Usage: "List the records which need migrating without rewriting them"}),
+					ArgsUsage:   "",
+					Description: "Rewrites records stored by older releases in the current schema. Run it after upgrading every volplugin, volmaster and volsupervisor.",
+					Usage:       "Migrate stored records to the current schema",
+					Action:      volcli.AdminMigrate,
+				},
+			},
+		},
 		{
 			Name:  "mount",
 			Usage: "Manage Mounts",
@@ -139,7 +303,7 @@ func main() {
 				{
 					Name:        "list",
 					Usage:       "List mounts",
-					Description: "List the mounts the volmaster knows about, in newline-delimited form.",
+					Description: "List the mounts the volmaster knows about, as tenant/volume in newline-delimited form.",
 					ArgsUsage:   "",
 					Flags:       flags,
 					Action:      volcli.MountList,
@@ -147,16 +311,16 @@ func main() {
 				{
 					Name:        "get",
 					Usage:       "Get mount info",
-					Description: "Obtains the information on a specified mount. Requires that you know the pool and image name.",
-					ArgsUsage:   "[pool name] [volume name]",
+					Description: "Obtains the information on the mount of a volume, as a list with an entry for each host holding it. Requires a tenant and volume name.",
+					ArgsUsage:   "[tenant name] [volume name]",
 					Flags:       flags,
 					Action:      volcli.MountGet,
 				},
 				{
 					Name:        "force-remove",
-					ArgsUsage:   "[pool name] [volume name]",
+					ArgsUsage:   "[tenant name] [volume name]",
 					Usage:       "Forcefully remove mount information",
-					Description: "Force-remove a mount. Use this to correct unmounting errors or failing hosts if necessary. Requires that you know the pool and image name.",
+					Description: "Force-remove the mount of a volume, on every host holding it. Use this to correct unmounting errors or failing hosts if necessary. Requires a tenant and volume name.",
 					Flags:       flags,
 					Action:      volcli.MountForceRemove,
 				},
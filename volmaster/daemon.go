@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -26,7 +27,12 @@ func Daemon(config *config.TopLevelConfig, debug bool, listen string) {
 	router := map[string]func(http.ResponseWriter, *http.Request){
 		"/request": d.handleRequest,
 		"/create":  d.handleCreate,
+		"/adopt":   d.handleAdopt,
+		"/update":  d.handleUpdate,
+		"/migrate": d.handleMigrate,
+		"/trim":    d.handleTrim,
 		"/mount":   d.handleMount,
+		"/refresh": d.handleRefresh,
 		"/unmount": d.handleUnmount,
 		"/remove":  d.handleRemove,
 	}
@@ -35,6 +41,8 @@ func Daemon(config *config.TopLevelConfig, debug bool, listen string) {
 		r.HandleFunc(path, logHandler(path, debug, f)).Methods("POST")
 	}
 
+	r.HandleFunc("/options", logHandler("/options", debug, d.handleOptions)).Methods("GET")
+
 	if err := http.ListenAndServe(listen, r); err != nil {
 		log.Fatalf("Error starting volmaster: %v", err)
 	}
@@ -67,21 +75,57 @@ func (d daemonConfig) handleRemove(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	vc, err := d.config.GetVolume(req.Tenant, req.Volume)
+	// volumes which are held by a host cannot become removing. The revision
+	// is checked by the same compare-and-swap, so that a volume written since
+	// the request was made is not removed.
+	vc, err := d.config.TransitionVolumeAt(req.Tenant, req.Volume, req.Revision, config.StateRemoving, "")
 	if err != nil {
-		httpError(w, "obtaining volume configuration", err)
+		httpError(w, "removing volume", err)
 		return
 	}
 
 	if err := removeImage(vc); err != nil {
+		if _, err := d.config.TransitionVolume(req.Tenant, req.Volume, config.StateFailed, err.Error()); err != nil {
+			log.Errorf("Could not mark volume %s/%s failed: %v", req.Tenant, req.Volume, err)
+		}
+
 		httpError(w, "removing image", err)
 		return
 	}
 
-	if err := d.config.RemoveVolume(req.Tenant, req.Volume); err != nil {
+	// the image is gone, so the record goes whatever happened to it since.
+	if err := d.config.RemoveVolume(req.Tenant, req.Volume, 0); err != nil {
 		httpError(w, "clearing volume records", err)
 		return
 	}
+
+	// trim status only exists for volumes which have been trimmed.
+	d.config.RemoveTrim(req.Tenant, req.Volume)
+}
+
+func (d daemonConfig) handleTrim(w http.ResponseWriter, r *http.Request) {
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, "Reading request", err)
+		return
+	}
+
+	ts := &config.TrimStatus{}
+
+	if err := json.Unmarshal(content, ts); err != nil {
+		httpError(w, "Unmarshalling request", err)
+		return
+	}
+
+	if ts.Tenant == "" || ts.Volume == "" {
+		httpError(w, "Reading request", errors.New("tenant and volume are required"))
+		return
+	}
+
+	if err := d.config.PublishTrim(ts); err != nil {
+		httpError(w, "Could not publish trim status", err)
+		return
+	}
 }
 
 func (d daemonConfig) handleUnmount(w http.ResponseWriter, r *http.Request) {
@@ -91,7 +135,16 @@ func (d daemonConfig) handleUnmount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mt, err := d.config.GetMount(req.Pool, req.Volume)
+	// each read-only holder has a record of its own.
+	if req.ReadOnly {
+		if err := d.config.RemoveMount(req, false); err != nil {
+			httpError(w, "Could not publish mount information", err)
+		}
+
+		return
+	}
+
+	mt, err := d.config.GetMount(req.Tenant, req.Volume)
 	if err != nil {
 		httpError(w, "Could not retrieve mount information", err)
 		return
@@ -118,6 +171,52 @@ func (d daemonConfig) handleMount(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleRefresh renews the TTL of a mount for the host holding it.
+func (d daemonConfig) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	req, err := unmarshalMountConfig(r)
+	if err != nil {
+		httpError(w, "Unmarshalling request", err)
+		return
+	}
+
+	err = d.config.RefreshMount(req)
+	if err == config.ErrNotExist {
+		// the record expired while the host could not refresh it, e.g. while
+		// etcd was unreachable. The host still holds the mount, so take it
+		// again unless another host has.
+		err = d.config.PublishMount(req)
+	}
+
+	if err != nil {
+		httpError(w, "Could not refresh mount information", err)
+		return
+	}
+}
+
+// handleOptions returns the option catalog. If the tenant query parameter is
+// supplied, the defaults are the tenant's.
+func (d daemonConfig) handleOptions(w http.ResponseWriter, r *http.Request) {
+	var defaults *config.VolumeOptions
+
+	if tenant := r.URL.Query().Get("tenant"); tenant != "" {
+		tc, _, err := d.config.ResolveTenant(tenant)
+		if err != nil {
+			httpError(w, "Resolving tenant", err)
+			return
+		}
+
+		defaults = &tc.DefaultVolumeOptions
+	}
+
+	content, err := json.Marshal(config.OptionCatalog(defaults))
+	if err != nil {
+		httpError(w, "Marshalling response", err)
+		return
+	}
+
+	w.Write(content)
+}
+
 func (d daemonConfig) handleRequest(w http.ResponseWriter, r *http.Request) {
 	req, err := unmarshalRequest(r)
 	if err != nil {
@@ -164,19 +263,262 @@ func (d daemonConfig) handleCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	volConfig, err := d.config.CreateVolume(req)
-	if err != config.ErrExist && volConfig != nil {
-		tenant, err := d.config.GetTenant(req.Tenant)
-		if err != nil {
+	volConfig, err := d.createVolume(req)
+	if err == config.ErrExist {
+		// docker creates volumes which exist already; they must be usable.
+		if err := volConfig.CheckMountable(); err != nil {
 			httpError(w, "Creating volume", err)
+			return
 		}
+	} else if err != nil {
+		httpError(w, "Creating volume", err)
+		return
+	}
+
+	content, err = json.Marshal(volConfig)
+	if err != nil {
+		httpError(w, "Marshalling response", err)
+		return
+	}
+
+	w.Write(content)
+}
+
+// createVolume records the volume, creates its image and makes it ready. If
+// a step fails, the steps which completed are undone so that the create can
+// be retried, and the error of the failed step is returned. Existing volumes
+// are returned with ErrExist.
+func (d daemonConfig) createVolume(req config.RequestCreate) (*config.VolumeConfig, error) {
+	volConfig, err := d.config.CreateVolume(req)
+	if err != nil {
+		return volConfig, err
+	}
+
+	tenant, _, err := d.config.ResolveTenant(req.Tenant)
+	if err != nil {
+		d.undoCreate(volConfig, false)
+		return nil, err
+	}
+
+	// createImage removes the image itself if it fails part way.
+	if err := createImage(tenant, volConfig); err != nil {
+		d.undoCreate(volConfig, false)
+		return nil, err
+	}
+
+	ready, err := d.config.TransitionVolume(req.Tenant, req.Volume, config.StateReady, "")
+	if err != nil {
+		d.undoCreate(volConfig, true)
+		return nil, err
+	}
+
+	return ready, nil
+}
+
+// undoCreate removes the image of a volume whose creation failed, if it was
+// created, and then its record. If the image cannot be removed, the record
+// is kept and marked failed instead, so that removing the volume retries.
+// Failures are logged; the caller reports the error which failed the create.
+func (d daemonConfig) undoCreate(vc *config.VolumeConfig, image bool) {
+	if image {
+		if err := removeImage(vc); err != nil {
+			log.Errorf("Could not remove the image of volume %s/%s after a failed create: %v", vc.TenantName, vc.VolumeName, err)
+
+			msg := fmt.Sprintf("removing the image after a failed create: %v", err)
+			if _, err := d.config.TransitionVolume(vc.TenantName, vc.VolumeName, config.StateFailed, msg); err != nil {
+				log.Errorf("Could not mark volume %s/%s failed: %v", vc.TenantName, vc.VolumeName, err)
+			}
 
-		if err := createImage(tenant, volConfig); err != nil {
-			httpError(w, "Creating volume", err)
 			return
 		}
-	} else if err != nil && err != config.ErrExist {
-		httpError(w, "Creating volume", err)
+	}
+
+	if err := d.config.RemoveVolume(vc.TenantName, vc.VolumeName, 0); err != nil {
+		log.Errorf("Could not remove the record of volume %s/%s after a failed create: %v", vc.TenantName, vc.VolumeName, err)
+	}
+}
+
+func (d daemonConfig) handleAdopt(w http.ResponseWriter, r *http.Request) {
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, "Reading request", err)
+		return
+	}
+
+	var req config.RequestAdopt
+
+	if err := json.Unmarshal(content, &req); err != nil {
+		httpError(w, "Unmarshalling request", err)
+		return
+	}
+
+	if req.Tenant == "" || req.Volume == "" || req.Pool == "" || req.Image == "" {
+		httpError(w, "Reading request", errors.New("tenant, volume, pool and image are required"))
+		return
+	}
+
+	if _, err := d.config.GetVolume(req.Tenant, req.Volume); err == nil {
+		httpError(w, "Adopting volume", config.ErrExist)
+		return
+	}
+
+	if err := d.config.CheckImage(req.Pool, req.Image); err != nil {
+		httpError(w, "Adopting volume", err)
+		return
+	}
+
+	opts, err := inspectImage(req.Pool, req.Image)
+	if err != nil {
+		httpError(w, "Inspecting image", err)
+		return
+	}
+
+	// the image is renamed before the volume is recorded, so that a crash in
+	// between leaves an image no volume refers to, which can be adopted again
+	// under its new name, rather than a volume whose image does not exist.
+	image := req.Image
+	if req.Rename {
+		image = config.ImageName(req.Tenant, req.Volume)
+		if err := renameImage(req.Pool, req.Image, image); err != nil {
+			httpError(w, "Renaming image", err)
+			return
+		}
+	}
+
+	volConfig, err := d.config.AdoptVolume(config.RequestCreate{Tenant: req.Tenant, Volume: req.Volume, Opts: opts}, image)
+	if err != nil {
+		if req.Rename {
+			if err := renameImage(req.Pool, image, req.Image); err != nil {
+				log.Errorf("Could not rename image %s/%s back to %s: %v", req.Pool, image, req.Image, err)
+			}
+		}
+
+		httpError(w, "Adopting volume", err)
+		return
+	}
+
+	volConfig, err = d.config.TransitionVolume(req.Tenant, req.Volume, config.StateReady, "")
+	if err != nil {
+		httpError(w, "Adopting volume", err)
+		return
+	}
+
+	content, err = json.Marshal(volConfig)
+	if err != nil {
+		httpError(w, "Marshalling response", err)
+		return
+	}
+
+	w.Write(content)
+}
+
+func (d daemonConfig) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, "Reading request", err)
+		return
+	}
+
+	var req config.RequestUpdate
+
+	if err := json.Unmarshal(content, &req); err != nil {
+		httpError(w, "Unmarshalling request", err)
+		return
+	}
+
+	if req.Tenant == "" || req.Volume == "" {
+		httpError(w, "Reading request", errors.New("tenant and volume are required"))
+		return
+	}
+
+	volConfig, err := d.config.UpdateVolume(req)
+	if err != nil {
+		httpError(w, "Updating volume", err)
+		return
+	}
+
+	content, err = json.Marshal(volConfig)
+	if err != nil {
+		httpError(w, "Marshalling response", err)
+		return
+	}
+
+	w.Write(content)
+}
+
+// abortMigrate makes a volume whose migration failed ready again. Failures
+// are logged; the caller reports the error which failed the migration.
+func (d daemonConfig) abortMigrate(vc *config.VolumeConfig) {
+	if _, err := d.config.TransitionVolume(vc.TenantName, vc.VolumeName, config.StateReady, ""); err != nil {
+		log.Errorf("Could not make volume %s/%s ready after a failed migration: %v", vc.TenantName, vc.VolumeName, err)
+	}
+}
+
+func (d daemonConfig) handleMigrate(w http.ResponseWriter, r *http.Request) {
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, "Reading request", err)
+		return
+	}
+
+	var req config.RequestMigrate
+
+	if err := json.Unmarshal(content, &req); err != nil {
+		httpError(w, "Unmarshalling request", err)
+		return
+	}
+
+	if req.Pool == "" {
+		httpError(w, "Reading request", errors.New("pool was blank"))
+		return
+	}
+
+	volConfig, err := d.config.GetVolume(req.Tenant, req.Volume)
+	if err != nil {
+		httpError(w, "obtaining volume configuration", err)
+		return
+	}
+
+	if volConfig.Options.Pool == req.Pool {
+		httpError(w, "Migrating volume", fmt.Errorf("volume is already in pool %q", req.Pool))
+		return
+	}
+
+	// the volume cannot be mounted until the migration is over, so nothing
+	// writes to the source image while it is copied. Volumes held by a host
+	// cannot become migrating.
+	volConfig, err = d.config.TransitionVolume(req.Tenant, req.Volume, config.StateMigrating, "")
+	if err != nil {
+		httpError(w, "Migrating volume", err)
+		return
+	}
+
+	if err := copyImage(volConfig, req.Pool, req.Snapshots); err != nil {
+		d.abortMigrate(volConfig)
+		httpError(w, "Copying image", err)
+		return
+	}
+
+	oldConfig := *volConfig
+	opts := *volConfig.Options
+	opts.Pool = req.Pool
+	volConfig.Options = &opts
+	volConfig.State = config.StateReady
+
+	// the switch fails if the volume changed since it became migrating.
+	if err := d.config.PublishVolume(volConfig); err != nil {
+		if rmErr := removeImage(volConfig); rmErr != nil {
+			log.Errorf("Could not remove the copy %s/%s of volume %s/%s; it is orphaned: %v", req.Pool, volConfig.ImageName(), req.Tenant, req.Volume, rmErr)
+			err = fmt.Errorf("%v; removing the copy %s/%s failed too, remove it by hand: %v", err, req.Pool, volConfig.ImageName(), rmErr)
+		}
+
+		d.abortMigrate(&oldConfig)
+		httpError(w, "Publishing volume configuration", err)
+		return
+	}
+
+	if err := removeImage(&oldConfig); err != nil {
+		httpError(w, "Removing source image", err)
 		return
 	}
 
@@ -2,7 +2,8 @@ package volmaster
 
 import (
 	"fmt"
-	"strings"
+	"reflect"
+	"strconv"
 
 	"github.com/contiv/volplugin/cephdriver"
 	"github.com/contiv/volplugin/config"
@@ -10,10 +11,6 @@ import (
 
 const defaultFsCmd = "mkfs.ext4 -m0 %"
 
-func joinVolumeName(config *config.VolumeConfig) string {
-	return strings.Join([]string{config.TenantName, config.VolumeName}, ".")
-}
-
 func createImage(tenant *config.TenantConfig, config *config.VolumeConfig) error {
 	var (
 		fscmd string
@@ -29,9 +26,154 @@ func createImage(tenant *config.TenantConfig, config *config.VolumeConfig) error
 		}
 	}
 
-	return cephdriver.NewCephDriver().NewVolume(config.Options.Pool, joinVolumeName(config), config.Options.Size).Create(fscmd)
+	vol := cephdriver.NewCephDriver().NewVolume(config.Options.Pool, config.ImageName(), config.Options.Size)
+	vol.MapMode = config.Options.MapMode
+
+	return vol.Create(fscmd)
 }
 
 func removeImage(config *config.VolumeConfig) error {
-	return cephdriver.NewCephDriver().NewVolume(config.Options.Pool, joinVolumeName(config), 0).Remove()
+	vol := cephdriver.NewCephDriver().NewVolume(config.Options.Pool, config.ImageName(), 0)
+
+	// volumes which failed to be created may have no image.
+	ok, err := vol.Exists()
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return nil
+	}
+
+	return vol.Remove()
+}
+
+// inspectImage yields the options which describe a pre-existing image, in
+// the form the merge layer accepts.
+func inspectImage(pool, image string) (map[string]string, error) {
+	vol := cephdriver.NewCephDriver().NewVolume(pool, image, 0)
+
+	ok, err := vol.Exists()
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("Image %s/%s does not exist", pool, image)
+	}
+
+	size, err := vol.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	fs, err := vol.FileSystem()
+	if err != nil {
+		return nil, err
+	}
+
+	if fs == "" {
+		return nil, fmt.Errorf("Image %s/%s does not contain a filesystem", pool, image)
+	}
+
+	return map[string]string{
+		"pool":       pool,
+		"size":       strconv.FormatUint(size, 10),
+		"filesystem": fs,
+	}, nil
+}
+
+func renameImage(pool, image, newName string) error {
+	return cephdriver.NewCephDriver().NewVolume(pool, image, 0).Rename(newName)
+}
+
+// copyImage copies the volume's image into the target pool and verifies the
+// copy against the source. The copy is removed if verification fails.
+func copyImage(config *config.VolumeConfig, pool string, snapshots bool) error {
+	driver := cephdriver.NewCephDriver()
+
+	ok, err := driver.PoolExists(pool)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("Pool %q does not exist", pool)
+	}
+
+	src := driver.NewVolume(config.Options.Pool, config.ImageName(), config.Options.Size)
+
+	dst, err := src.Copy(pool, snapshots)
+	if err != nil {
+		return err
+	}
+
+	if err := verifyCopy(src, dst, snapshots); err != nil {
+		dst.Remove()
+		return err
+	}
+
+	return nil
+}
+
+// verifyCopy compares the copy of an image with the source: their size,
+// their contents and, if snapshots were copied, the names and contents of
+// their snapshots.
+func verifyCopy(src, dst *cephdriver.CephVolume, snapshots bool) error {
+	srcSize, err := src.Size()
+	if err != nil {
+		return err
+	}
+
+	dstSize, err := dst.Size()
+	if err != nil {
+		return err
+	}
+
+	if srcSize != dstSize {
+		return fmt.Errorf("Copy of %v has size %d, expected %d", src, dstSize, srcSize)
+	}
+
+	snaps := []string{""}
+
+	if snapshots {
+		srcSnaps, err := src.ListSnapshots()
+		if err != nil {
+			return err
+		}
+
+		dstSnaps, err := dst.ListSnapshots()
+		if err != nil {
+			return err
+		}
+
+		if !reflect.DeepEqual(srcSnaps, dstSnaps) {
+			return fmt.Errorf("Copy of %v has snapshots %v, expected %v", src, dstSnaps, srcSnaps)
+		}
+
+		snaps = append(snaps, srcSnaps...)
+	}
+
+	for _, snap := range snaps {
+		srcSum, err := src.Checksum(snap)
+		if err != nil {
+			return err
+		}
+
+		dstSum, err := dst.Checksum(snap)
+		if err != nil {
+			return err
+		}
+
+		if srcSum != dstSum {
+			what := "contents"
+			if snap != "" {
+				what = fmt.Sprintf("snapshot %q", snap)
+			}
+
+			return fmt.Errorf("Copy of %v differs from the source in its %s", src, what)
+		}
+	}
+
+	return nil
 }
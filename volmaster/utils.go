@@ -19,11 +19,18 @@ func errExit(ctx *cli.Context, err error) {
 	os.Exit(1)
 }
 
+// httpError reports the error to the client. Revision conflicts are reported
+// with 409 Conflict, so that clients can tell them from other failures.
 func httpError(w http.ResponseWriter, message string, err error) {
 	fullError := fmt.Sprintf("%s %v", message, err)
 
+	status := http.StatusInternalServerError
+	if err == config.ErrConflict {
+		status = http.StatusConflict
+	}
+
 	log.Warnf("Returning HTTP error handling plugin negotiation: %s", fullError)
-	http.Error(w, fullError, http.StatusInternalServerError)
+	http.Error(w, fullError, status)
 }
 
 func unmarshalRequest(r *http.Request) (config.Request, error) {
@@ -16,11 +16,13 @@ func start(ctx *cli.Context) {
 		log.Debug("Debug logging enabled")
 	}
 
-	cfg, err := config.NewTopLevelConfig(ctx.String("prefix"), ctx.StringSlice("etcd"))
+	store, err := config.NewStore(ctx.String("etcd-api"), ctx.StringSlice("etcd"))
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	cfg := config.NewTopLevelConfigWithStore(ctx.String("prefix"), store)
+
 	volmaster.Daemon(cfg, ctx.Bool("debug"), ctx.String("listen"))
 }
 
@@ -51,6 +53,11 @@ func main() {
 			Usage: "URL for etcd",
 			Value: &cli.StringSlice{"http://localhost:2379"},
 		},
+		cli.StringFlag{
+			Name:  "etcd-api",
+			Usage: "etcd API version to use: v2 or v3",
+			Value: "v2",
+		},
 	}
 	app.Run(os.Args)
 }
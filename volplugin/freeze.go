@@ -0,0 +1,163 @@
+package volplugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/volplugin/config"
+	"github.com/gorilla/mux"
+)
+
+// maxFreezeTimeout caps the time a filesystem may be held frozen, whatever
+// the caller asks for.
+const maxFreezeTimeout = 5 * time.Minute
+
+// freezeCollection holds the timers which thaw frozen volumes if the thaw
+// request never arrives.
+type freezeCollection struct {
+	mutex  sync.Mutex
+	timers map[string]*time.Timer
+}
+
+var frozenVolumes = &freezeCollection{timers: map[string]*time.Timer{}}
+
+func (fc *freezeCollection) freeze(vc *config.VolumeConfig, timeout time.Duration) error {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	key := path.Join(vc.TenantName, vc.VolumeName)
+	if _, ok := fc.timers[key]; ok {
+		return fmt.Errorf("Volume %q is already frozen", key)
+	}
+
+	if err := newVolume(vc).Freeze(); err != nil {
+		return err
+	}
+
+	fc.timers[key] = time.AfterFunc(timeout, func() {
+		log.Warnf("Volume %q was not thawed within %v; thawing", key, timeout)
+		if err := fc.thaw(vc); err != nil {
+			log.Errorf("Thawing volume %q failed: %v", key, err)
+		}
+	})
+
+	return nil
+}
+
+func (fc *freezeCollection) thaw(vc *config.VolumeConfig) error {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	key := path.Join(vc.TenantName, vc.VolumeName)
+	timer, ok := fc.timers[key]
+	if !ok {
+		return fmt.Errorf("Volume %q is not frozen", key)
+	}
+
+	timer.Stop()
+	delete(fc.timers, key)
+
+	return newVolume(vc).Thaw()
+}
+
+func (fc *freezeCollection) isFrozen(tenant, name string) bool {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	_, ok := fc.timers[path.Join(tenant, name)]
+	return ok
+}
+
+// serveControl serves the operations other volplugin components request of
+// this host, such as freezing volumes for snapshots.
+func serveControl(debug bool, listen string) error {
+	routeMap := map[string]func(http.ResponseWriter, *http.Request){
+		"/freeze": freeze,
+		"/thaw":   thaw,
+	}
+
+	router := mux.NewRouter()
+	s := router.Methods("POST").Subrouter()
+
+	for key, value := range routeMap {
+		s.HandleFunc(key, logHandler(key[1:], debug, value))
+	}
+
+	return http.ListenAndServe(listen, router)
+}
+
+func unmarshalFreezeRequest(r *http.Request) (*config.RequestFreeze, error) {
+	req := &config.RequestFreeze{}
+
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, req); err != nil {
+		return nil, err
+	}
+
+	if req.Tenant == "" || req.Volume == "" {
+		return nil, fmt.Errorf("tenant and volume are required")
+	}
+
+	return req, nil
+}
+
+func heldVolume(tenant, name string) (*config.VolumeConfig, error) {
+	vc, ok := mountedVolumes.list()[path.Join(tenant, name)]
+	if !ok {
+		return nil, fmt.Errorf("Volume %s/%s is not mounted on this host", tenant, name)
+	}
+
+	return vc, nil
+}
+
+func freeze(w http.ResponseWriter, r *http.Request) {
+	req, err := unmarshalFreezeRequest(r)
+	if err != nil {
+		httpError(w, "Could not unmarshal request", err)
+		return
+	}
+
+	vc, err := heldVolume(req.Tenant, req.Volume)
+	if err != nil {
+		httpError(w, "Freezing volume", err)
+		return
+	}
+
+	timeout := req.Timeout
+	if timeout <= 0 || timeout > maxFreezeTimeout {
+		timeout = maxFreezeTimeout
+	}
+
+	if err := frozenVolumes.freeze(vc, timeout); err != nil {
+		httpError(w, "Freezing volume", err)
+		return
+	}
+}
+
+func thaw(w http.ResponseWriter, r *http.Request) {
+	req, err := unmarshalFreezeRequest(r)
+	if err != nil {
+		httpError(w, "Could not unmarshal request", err)
+		return
+	}
+
+	vc, err := heldVolume(req.Tenant, req.Volume)
+	if err != nil {
+		httpError(w, "Thawing volume", err)
+		return
+	}
+
+	if err := frozenVolumes.thaw(vc); err != nil {
+		httpError(w, "Thawing volume", err)
+		return
+	}
+}
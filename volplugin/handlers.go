@@ -4,7 +4,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
-	"os"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/contiv/volplugin/cephdriver"
@@ -97,7 +97,7 @@ func create(master string) func(http.ResponseWriter, *http.Request) {
 		}
 
 		if err := requestCreate(master, tenant, name, vr.Opts); err != nil {
-			httpError(w, "Could not determine tenant configuration", err)
+			httpError(w, "Could not create volume:", err)
 			return
 		}
 
@@ -141,7 +141,7 @@ func getPath(master string) func(http.ResponseWriter, *http.Request) {
 		// FIXME need to ensure that the mount exists before returning to docker
 		driver := cephdriver.NewCephDriver()
 
-		content, err := marshalResponse(VolumeResponse{Mountpoint: driver.MountPath(volConfig.Options.Pool, name)})
+		content, err := marshalResponse(VolumeResponse{Mountpoint: driver.MountPath(volConfig.Options.Pool, volConfig.ImageName())})
 		if err != nil {
 			httpError(w, "Reply could not be marshalled", err)
 			return
@@ -151,7 +151,7 @@ func getPath(master string) func(http.ResponseWriter, *http.Request) {
 	}
 }
 
-func mount(master, host string) func(http.ResponseWriter, *http.Request) {
+func mount(master, host string, ttl time.Duration) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vr, err := unmarshalRequest(r.Body)
 		if err != nil {
@@ -164,7 +164,6 @@ func mount(master, host string) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 
-		// FIXME check if we're holding the mount already
 		log.Infof("Mounting volume %q", vr.Name)
 
 		tenant, name, err := splitPath(vr.Name)
@@ -173,27 +172,33 @@ func mount(master, host string) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 
+		unlock := mountedVolumes.lock(tenant, name)
+		defer unlock()
+
+		// another container on this host holds the volume already.
+		if volConfig, ok := mountedVolumes.ref(tenant, name, vr.ID); ok {
+			writeMountpoint(w, volConfig)
+			return
+		}
+
 		volConfig, err := requestVolumeConfig(master, tenant, name)
 		if err != nil {
 			httpError(w, "Could not determine tenant configuration", err)
 			return
 		}
 
-		driver := cephdriver.NewCephDriver()
-
-		mt := &config.MountConfig{
-			Volume:     volConfig.VolumeName,
-			Pool:       volConfig.Options.Pool,
-			MountPoint: driver.MountPath(volConfig.Options.Pool, joinPath(tenant, name)),
-			Host:       host,
+		// half-created, failed and removing volumes have no usable image.
+		if err := volConfig.CheckMountable(); err != nil {
+			httpError(w, "Volume cannot be mounted", err)
+			return
 		}
 
-		if err := reportMount(master, mt); err != nil {
+		if err := reportMount(master, mountConfig(volConfig, host, ttl)); err != nil {
 			httpError(w, "Reporting mount to master", err)
 			return
 		}
 
-		mc, err := driver.NewVolume(volConfig.Options.Pool, joinPath(tenant, name), volConfig.Options.Size).Mount(volConfig.Options.FileSystem)
+		mc, err := newVolume(volConfig).Mount(volConfig.Options.FileSystem)
 		if err != nil {
 			httpError(w, "Volume could not be mounted", err)
 			return
@@ -204,17 +209,13 @@ func mount(master, host string) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 
-		content, err := marshalResponse(VolumeResponse{Mountpoint: driver.MountPath(volConfig.Options.Pool, joinPath(tenant, name))})
-		if err != nil {
-			httpError(w, "Reply could not be marshalled", err)
-			return
-		}
+		mountedVolumes.add(volConfig, vr.ID)
 
-		w.Write(content)
+		writeMountpoint(w, volConfig)
 	}
 }
 
-func unmount(master string) func(http.ResponseWriter, *http.Request) {
+func unmount(master, host string, ttl time.Duration) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vr, err := unmarshalRequest(r.Body)
 		if err != nil {
@@ -235,45 +236,60 @@ func unmount(master string) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 
-		volConfig, err := requestVolumeConfig(master, tenant, name)
-		if err != nil {
-			httpError(w, "Could not determine tenant configuration", err)
-			return
-		}
+		unlock := mountedVolumes.lock(tenant, name)
+		defer unlock()
 
-		driver := cephdriver.NewCephDriver()
+		// unmount the volume as it was mounted, even if its options, such as
+		// shared-readonly, changed since. Volumes which other containers on
+		// this host still use stay mounted.
+		volConfig, ok := mountedVolumes.get(tenant, name)
+		if ok {
+			if refs := mountedVolumes.unref(tenant, name, vr.ID); refs > 0 {
+				log.Infof("Volume %q is still used by %d other container(s); not unmounting", vr.Name, refs)
+				writeMountpoint(w, volConfig)
+				return
+			}
+		} else {
+			volConfig, err = requestVolumeConfig(master, tenant, name)
+			if err != nil {
+				httpError(w, "Could not determine tenant configuration", err)
+				return
+			}
+		}
 
-		if err := driver.NewVolume(volConfig.Options.Pool, joinPath(tenant, name), volConfig.Options.Size).Unmount(); err != nil {
-			httpError(w, "Could not unmount image", err)
-			return
+		// a frozen filesystem cannot be unmounted.
+		if frozenVolumes.isFrozen(tenant, name) {
+			if err := frozenVolumes.thaw(volConfig); err != nil {
+				httpError(w, "Could not thaw frozen volume", err)
+				return
+			}
 		}
 
-		hostname, err := os.Hostname()
-		if err != nil {
-			httpError(w, "Retrieving hostname", err)
+		if err := newVolume(volConfig).Unmount(); err != nil {
+			httpError(w, "Could not unmount image", err)
 			return
 		}
 
-		mt := &config.MountConfig{
-			Volume:     name,
-			MountPoint: driver.MountPath(volConfig.Options.Pool, joinPath(tenant, name)),
-			Pool:       volConfig.Options.Pool,
-			Host:       hostname,
-		}
+		mountedVolumes.remove(tenant, name)
 
-		if err := reportUnmount(master, mt); err != nil {
+		if err := reportUnmount(master, mountConfig(volConfig, host, ttl)); err != nil {
 			httpError(w, "Reporting unmount to master", err)
 			return
 		}
 
-		content, err := marshalResponse(VolumeResponse{Mountpoint: driver.MountPath(volConfig.Options.Pool, joinPath(tenant, name))})
-		if err != nil {
-			httpError(w, "Reply could not be marshalled", err)
-			return
-		}
+		writeMountpoint(w, volConfig)
+	}
+}
 
-		w.Write(content)
+// writeMountpoint replies with the mount point of the volume.
+func writeMountpoint(w http.ResponseWriter, vc *config.VolumeConfig) {
+	content, err := marshalResponse(VolumeResponse{Mountpoint: cephdriver.NewCephDriver().MountPath(vc.Options.Pool, vc.ImageName())})
+	if err != nil {
+		httpError(w, "Reply could not be marshalled", err)
+		return
 	}
+
+	w.Write(content)
 }
 
 // Catchall for additional driver functions.
@@ -0,0 +1,245 @@
+package volplugin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/volplugin/config"
+)
+
+// mountStatePath is where the mount collection is persisted, so that a
+// restarted volplugin knows what it has mounted. It lives under /run, as
+// mounts do not survive a reboot either.
+const mountStatePath = "/run/volplugin/mounts.json"
+
+// mountState is the state of a volume mounted on this host: its
+// configuration as it was mounted, the references held on it, keyed by the
+// mount ID docker supplies, and when it was last trimmed, or mounted if it
+// has not been trimmed since. Docker versions which supply no ID hold
+// references under the empty ID.
+type mountState struct {
+	Volume   *config.VolumeConfig `json:"volume"`
+	Refs     map[string]int       `json:"refs"`
+	LastTrim time.Time            `json:"last-trim"`
+}
+
+// mountCollection tracks the volumes this host has mounted, so that background
+// tasks can act on them, and counts the references containers hold on them,
+// so that a volume is only unmounted when the last container lets go of it.
+type mountCollection struct {
+	mutex   sync.Mutex
+	volumes map[string]*mountState
+	locks   map[string]*sync.Mutex
+	path    string
+}
+
+var mountedVolumes = &mountCollection{volumes: map[string]*mountState{}, locks: map[string]*sync.Mutex{}}
+
+// lock serializes the mounts and unmounts of a volume. The returned function
+// releases the lock.
+func (mc *mountCollection) lock(tenant, name string) func() {
+	mc.mutex.Lock()
+	lock, ok := mc.locks[path.Join(tenant, name)]
+	if !ok {
+		lock = &sync.Mutex{}
+		mc.locks[path.Join(tenant, name)] = lock
+	}
+	mc.mutex.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// add records that the volume was mounted for the mount ID.
+func (mc *mountCollection) add(vc *config.VolumeConfig, id string) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	key := path.Join(vc.TenantName, vc.VolumeName)
+	if _, ok := mc.volumes[key]; !ok {
+		mc.volumes[key] = &mountState{Volume: vc, Refs: map[string]int{}, LastTrim: time.Now()}
+	}
+
+	mc.volumes[key].Refs[id]++
+	mc.save()
+}
+
+// ref adds a reference for the mount ID if the volume is mounted already,
+// and returns the volume as it was mounted.
+func (mc *mountCollection) ref(tenant, name, id string) (*config.VolumeConfig, bool) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	state, ok := mc.volumes[path.Join(tenant, name)]
+	if !ok {
+		return nil, false
+	}
+
+	state.Refs[id]++
+	mc.save()
+
+	return state.Volume, true
+}
+
+// unref drops the reference of the mount ID and returns the number of
+// references left. Volumes which are not tracked have none.
+func (mc *mountCollection) unref(tenant, name, id string) int {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	state, ok := mc.volumes[path.Join(tenant, name)]
+	if !ok {
+		return 0
+	}
+
+	if state.Refs[id] > 0 {
+		state.Refs[id]--
+		if state.Refs[id] == 0 {
+			delete(state.Refs, id)
+		}
+		mc.save()
+	} else {
+		log.Warnf("Volume %s/%s holds no reference for mount ID %q", tenant, name, id)
+	}
+
+	count := 0
+	for _, refs := range state.Refs {
+		count += refs
+	}
+
+	return count
+}
+
+func (mc *mountCollection) remove(tenant, name string) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	delete(mc.volumes, path.Join(tenant, name))
+	mc.save()
+}
+
+// get returns the volume as it was when it was mounted, if it is mounted.
+func (mc *mountCollection) get(tenant, name string) (*config.VolumeConfig, bool) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	state, ok := mc.volumes[path.Join(tenant, name)]
+	if !ok {
+		return nil, false
+	}
+
+	return state.Volume, true
+}
+
+// list returns a copy of the collection, keyed by tenant/volume.
+func (mc *mountCollection) list() map[string]*config.VolumeConfig {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	volumes := map[string]*config.VolumeConfig{}
+	for key, state := range mc.volumes {
+		volumes[key] = state.Volume
+	}
+
+	return volumes
+}
+
+// lastTrim returns when the volume was last trimmed, if it is mounted.
+func (mc *mountCollection) lastTrim(tenant, name string) (time.Time, bool) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	state, ok := mc.volumes[path.Join(tenant, name)]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return state.LastTrim, true
+}
+
+// trimmed records that the volume was trimmed at the time supplied, so that a
+// restarted volplugin keeps to the trim frequency.
+func (mc *mountCollection) trimmed(tenant, name string, at time.Time) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	if state, ok := mc.volumes[path.Join(tenant, name)]; ok {
+		state.LastTrim = at
+		mc.save()
+	}
+}
+
+// load restores the collection persisted at statePath, and persists it there
+// from now on. A missing file is an empty collection.
+func (mc *mountCollection) load(statePath string) error {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	mc.path = statePath
+
+	content, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	volumes := map[string]*mountState{}
+	if err := json.Unmarshal(content, &volumes); err != nil {
+		return err
+	}
+
+	for key, state := range volumes {
+		if state.Volume == nil {
+			delete(volumes, key)
+			continue
+		}
+
+		if state.Refs == nil {
+			state.Refs = map[string]int{}
+		}
+
+		// state saved before trims were recorded counts from the restart.
+		if state.LastTrim.IsZero() {
+			state.LastTrim = time.Now()
+		}
+	}
+
+	mc.volumes = volumes
+	return nil
+}
+
+// save persists the collection. It must be called with the mutex held.
+// Failures are logged; the collection in memory stays authoritative.
+func (mc *mountCollection) save() {
+	if mc.path == "" {
+		return
+	}
+
+	content, err := json.Marshal(mc.volumes)
+	if err != nil {
+		log.Errorf("Could not marshal the mount state: %v", err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(mc.path), 0700); err != nil {
+		log.Errorf("Could not save the mount state: %v", err)
+		return
+	}
+
+	// write and rename, so that a crash leaves the old state or the new one.
+	tmp := mc.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		log.Errorf("Could not save the mount state: %v", err)
+		return
+	}
+
+	if err := os.Rename(tmp, mc.path); err != nil {
+		log.Errorf("Could not save the mount state: %v", err)
+	}
+}
@@ -0,0 +1,24 @@
+package volplugin
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// scheduleMountRefresh refreshes the mount records of the volumes this host
+// has mounted every interval, so that they do not expire while the volumes
+// are in use. A failed refresh, e.g. while etcd is unreachable, is logged and
+// tried again on the next round; records outlive missed refreshes as long as
+// the interval is well below the TTL. It hangs until the program terminates.
+func scheduleMountRefresh(master, host string, ttl, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		for key, vc := range mountedVolumes.list() {
+			if err := reportRefresh(master, mountConfig(vc, host, ttl)); err != nil {
+				log.Errorf("Refreshing the mount of volume %s failed: %v", key, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package volplugin
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/volplugin/config"
+)
+
+// trimCheckInterval is how often scheduleTrim checks the mounted volumes.
+const trimCheckInterval = 10 * time.Second
+
+// scheduleTrim trims each mounted volume which has the trim option set,
+// according to its frequency. The option is read from the volmaster on each
+// check, so that changes take effect without remounting the volume. The time
+// of the last trim is kept with the mounted volume, so the schedule survives a
+// restart of the volplugin. It hangs until the program terminates.
+func scheduleTrim(master, host string) {
+	for {
+		for _, vc := range mountedVolumes.list() {
+			// read-only filesystems cannot be trimmed.
+			if vc.Options.SharedReadOnly {
+				continue
+			}
+
+			current, err := requestVolumeConfig(master, vc.TenantName, vc.VolumeName)
+			if err != nil {
+				log.Errorf("Could not read the configuration of volume %s/%s to trim it: %v", vc.TenantName, vc.VolumeName, err)
+				continue
+			}
+
+			if current.Options.Trim == "" {
+				continue
+			}
+
+			duration, err := config.ParseFrequency(current.Options.Trim)
+			if err != nil {
+				log.Errorf("Runtime configuration incorrect; cannot use %q as a trim frequency: %v", current.Options.Trim, err)
+				continue
+			}
+
+			// volumes are not trimmed straight after they are mounted.
+			last, ok := mountedVolumes.lastTrim(vc.TenantName, vc.VolumeName)
+			if !ok || time.Since(last) < duration {
+				continue
+			}
+
+			runTrim(master, host, vc)
+			mountedVolumes.trimmed(vc.TenantName, vc.VolumeName, time.Now())
+		}
+
+		time.Sleep(trimCheckInterval)
+	}
+}
+
+func runTrim(master, host string, vc *config.VolumeConfig) {
+	log.Debugf("Trimming volume %s/%s", vc.TenantName, vc.VolumeName)
+
+	now := time.Now()
+	trimmed, err := newVolume(vc).Trim()
+	if err != nil {
+		log.Errorf("Trimming volume %s/%s failed: %v", vc.TenantName, vc.VolumeName, err)
+		return
+	}
+
+	log.Infof("Trimmed %d bytes from volume %s/%s", trimmed, vc.TenantName, vc.VolumeName)
+
+	ts := &config.TrimStatus{
+		Tenant:         vc.TenantName,
+		Volume:         vc.VolumeName,
+		Host:           host,
+		LastTrim:       now,
+		BytesReclaimed: trimmed,
+	}
+
+	if err := reportTrim(master, ts); err != nil {
+		log.Errorf("Reporting trim of volume %s/%s to master failed: %v", vc.TenantName, vc.VolumeName, err)
+	}
+}
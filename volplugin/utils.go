@@ -8,8 +8,10 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/volplugin/cephdriver"
 	"github.com/contiv/volplugin/config"
 )
 
@@ -105,8 +107,10 @@ func requestCreate(host, tenantName, name string, opts map[string]string) error
 
 	content, err = ioutil.ReadAll(resp.Body)
 
+	// the volmaster's error, such as an exceeded quota, is passed on verbatim
+	// so that it is readable in the docker CLI.
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("Status was not 200: was %d: %q", resp.StatusCode, strings.TrimSpace(string(content)))
+		return fmt.Errorf("%s", strings.TrimSpace(string(content)))
 	}
 
 	return nil
@@ -132,6 +136,29 @@ func reportMount(host string, mt *config.MountConfig) error {
 	return nil
 }
 
+// reportRefresh renews the TTL of the mount record through the volmaster.
+func reportRefresh(host string, mt *config.MountConfig) error {
+	content, err := json.Marshal(mt)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(fmt.Sprintf("http://%s/refresh", host), "application/json", bytes.NewBuffer(content))
+	if err != nil {
+		return err
+	}
+
+	content, err = ioutil.ReadAll(resp.Body)
+
+	// the volmaster's error, such as an expired record, is passed on verbatim
+	// so that it is readable in the log.
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("%s", strings.TrimSpace(string(content)))
+	}
+
+	return nil
+}
+
 func reportUnmount(host string, mt *config.MountConfig) error {
 	content, err := json.Marshal(mt)
 	if err != nil {
@@ -152,6 +179,50 @@ func reportUnmount(host string, mt *config.MountConfig) error {
 	return nil
 }
 
+func reportTrim(host string, ts *config.TrimStatus) error {
+	content, err := json.Marshal(ts)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(fmt.Sprintf("http://%s/trim", host), "application/json", bytes.NewBuffer(content))
+	if err != nil {
+		return err
+	}
+
+	content, err = ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("Status was not 200: was %d: %q", resp.StatusCode, strings.TrimSpace(string(content)))
+	}
+
+	return nil
+}
+
+// mountConfig returns the mount record this host reports for the volume.
+func mountConfig(vc *config.VolumeConfig, host string, ttl time.Duration) *config.MountConfig {
+	return &config.MountConfig{
+		Tenant:     vc.TenantName,
+		Volume:     vc.VolumeName,
+		Pool:       vc.Options.Pool,
+		MountPoint: cephdriver.NewCephDriver().MountPath(vc.Options.Pool, vc.ImageName()),
+		Host:       host,
+		ReadOnly:   vc.Options.SharedReadOnly,
+		TTL:        ttl,
+	}
+}
+
+// newVolume returns the ceph volume backing the volume configuration.
+func newVolume(vc *config.VolumeConfig) *cephdriver.CephVolume {
+	vol := cephdriver.NewCephDriver().NewVolume(vc.Options.Pool, vc.ImageName(), vc.Options.Size)
+	vol.MapMode = vc.Options.MapMode
+	vol.ReadOnly = vc.Options.SharedReadOnly
+	return vol
+}
+
+// splitPath splits the docker volume name `tenant/volume` into the tenant
+// and volume names. They are only validated when the volume is created, by
+// the volmaster, so that volumes named before the current rules still work.
 func splitPath(name string) (string, string, error) {
 	parts := strings.SplitN(name, "/", 2)
 	if len(parts) != 2 {
@@ -160,7 +231,3 @@ func splitPath(name string) (string, string, error) {
 
 	return parts[0], parts[1], nil
 }
-
-func joinPath(tenant, name string) string {
-	return strings.Join([]string{tenant, name}, ".")
-}
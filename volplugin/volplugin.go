@@ -2,12 +2,14 @@ package volplugin
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
@@ -20,6 +22,10 @@ const basePath = "/run/docker/plugins"
 type VolumeRequest struct {
 	Name string
 	Opts map[string]string
+
+	// ID identifies the container's mount in Mount and Unmount requests, for
+	// docker versions which supply it.
+	ID string
 }
 
 // VolumeResponse is taken from
@@ -29,8 +35,15 @@ type VolumeResponse struct {
 	Err        string
 }
 
-// Daemon starts the volplugin service.
-func Daemon(debug bool, master, host string) error {
+// Daemon starts the volplugin service. listen is the address of the control
+// interface other volplugin components use to reach this host. Mount records
+// expire after mountTTL unless refreshed; they are refreshed every
+// mountRefresh while the volume is mounted.
+func Daemon(debug bool, master, host, listen string, mountTTL, mountRefresh time.Duration) error {
+	if mountTTL > 0 && mountRefresh >= mountTTL {
+		return fmt.Errorf("Mount refresh interval %v must be shorter than the mount TTL %v", mountRefresh, mountTTL)
+	}
+
 	driverPath := path.Join(basePath, "volplugin.sock")
 	os.Remove(driverPath)
 	if err := os.MkdirAll(basePath, 0700); err != nil {
@@ -46,19 +59,37 @@ func Daemon(debug bool, master, host string) error {
 		log.SetLevel(log.DebugLevel)
 	}
 
-	http.Serve(l, configureRouter(debug, master, host))
+	// pick up the volumes mounted before a restart, so that they are
+	// refreshed, trimmed and reference counted as before.
+	if err := mountedVolumes.load(mountStatePath); err != nil {
+		log.Errorf("Could not load the mount state, starting afresh: %v", err)
+	}
+
+	go scheduleTrim(master, host)
+
+	if mountTTL > 0 {
+		go scheduleMountRefresh(master, host, mountTTL, mountRefresh)
+	}
+
+	go func() {
+		if err := serveControl(debug, listen); err != nil {
+			log.Fatalf("Error starting control interface: %v", err)
+		}
+	}()
+
+	http.Serve(l, configureRouter(debug, master, host, mountTTL))
 	return l.Close()
 }
 
-func configureRouter(debug bool, master, host string) *mux.Router {
+func configureRouter(debug bool, master, host string, mountTTL time.Duration) *mux.Router {
 	var routeMap = map[string]func(http.ResponseWriter, *http.Request){
 		"/Plugin.Activate":      activate,
 		"/Plugin.Deactivate":    nilAction,
 		"/VolumeDriver.Create":  create(master),
 		"/VolumeDriver.Remove":  remove(master),
 		"/VolumeDriver.Path":    getPath(master),
-		"/VolumeDriver.Mount":   mount(master, host),
-		"/VolumeDriver.Unmount": unmount(master),
+		"/VolumeDriver.Mount":   mount(master, host, mountTTL),
+		"/VolumeDriver.Unmount": unmount(master, host, mountTTL),
 	}
 
 	router := mux.NewRouter()
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"fmt"
+	"net"
 	"os"
+	"time"
 
 	"github.com/codegangsta/cli"
 	"github.com/contiv/volplugin/volplugin"
@@ -39,6 +42,23 @@ func main() {
 			EnvVar: "HOSTLABEL",
 			Value:  host,
 		},
+		cli.StringFlag{
+			Name:   "control-listen",
+			Usage:  "Set the listen address for the control interface used by volsupervisor; defaults to port 8081 of the host label's address",
+			EnvVar: "CONTROL_LISTEN",
+		},
+		cli.DurationFlag{
+			Name:   "mount-ttl",
+			Usage:  "Set how long mount records live unless refreshed; 0 keeps them until unmount",
+			EnvVar: "MOUNT_TTL",
+			Value:  30 * time.Second,
+		},
+		cli.DurationFlag{
+			Name:   "mount-refresh",
+			Usage:  "Set how often mount records are refreshed; must be shorter than the TTL",
+			EnvVar: "MOUNT_REFRESH",
+			Value:  10 * time.Second,
+		},
 	}
 	app.Action = run
 
@@ -46,5 +66,15 @@ func main() {
 }
 
 func run(ctx *cli.Context) {
-	volplugin.Daemon(ctx.Bool("debug"), ctx.String("master"), ctx.String("host-label"))
+	// volsupervisor reaches the control interface through the host label, so
+	// it need not listen on any other interface.
+	listen := ctx.String("control-listen")
+	if listen == "" {
+		listen = net.JoinHostPort(ctx.String("host-label"), "8081")
+	}
+
+	if err := volplugin.Daemon(ctx.Bool("debug"), ctx.String("master"), ctx.String("host-label"), listen, ctx.Duration("mount-ttl"), ctx.Duration("mount-refresh")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
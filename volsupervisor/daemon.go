@@ -3,9 +3,11 @@ package volsupervisor
 import "github.com/contiv/volplugin/config"
 
 // Daemon implements the startup of the various services volsupervisor manages.
-// It hangs until the program terminates.
-func Daemon(cfg *config.TopLevelConfig) {
+// volpluginPort is the port of the volplugin control interface, which is used
+// to freeze mounted volumes while they are snapshotted. It hangs until the
+// program terminates.
+func Daemon(cfg *config.TopLevelConfig, volpluginPort string) {
 	go scheduleSnapshotPrune(cfg)
-	go scheduleSnapshots(cfg)
+	go scheduleSnapshots(cfg, volpluginPort)
 	select {}
 }
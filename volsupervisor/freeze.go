@@ -0,0 +1,48 @@
+package volsupervisor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/contiv/volplugin/config"
+)
+
+// freezeTimeout is the longest a volume is held frozen for a snapshot. The
+// volplugin thaws the volume by itself once it passes, even if the thaw
+// request never arrives.
+const freezeTimeout = 30 * time.Second
+
+var freezeClient = &http.Client{Timeout: freezeTimeout}
+
+func requestFreeze(host string, volume *config.VolumeConfig) error {
+	return postFreezeRequest(host, "freeze", volume)
+}
+
+func requestThaw(host string, volume *config.VolumeConfig) error {
+	return postFreezeRequest(host, "thaw", volume)
+}
+
+func postFreezeRequest(host, action string, volume *config.VolumeConfig) error {
+	content, err := json.Marshal(config.RequestFreeze{Tenant: volume.TenantName, Volume: volume.VolumeName, Timeout: freezeTimeout})
+	if err != nil {
+		return err
+	}
+
+	resp, err := freezeClient.Post(fmt.Sprintf("http://%s/%s", host, action), "application/json", bytes.NewBuffer(content))
+	if err != nil {
+		return err
+	}
+
+	content, err = ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("Status was not 200: was %d: %q", resp.StatusCode, strings.TrimSpace(string(content)))
+	}
+
+	return nil
+}
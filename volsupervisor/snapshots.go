@@ -1,7 +1,7 @@
 package volsupervisor
 
 import (
-	"strings"
+	"net"
 	"time"
 
 	"github.com/contiv/volplugin/cephdriver"
@@ -13,13 +13,19 @@ import (
 func wrapSnapshotAction(action func(config *config.TopLevelConfig, pool string, volume *config.VolumeConfig)) func(*volumeDispatch) {
 	return func(v *volumeDispatch) {
 		for _, volume := range v.volumes {
-			duration, err := time.ParseDuration(volume.Options.Snapshot.Frequency)
+			if !volume.Options.UseSnapshots {
+				continue
+			}
+
+			// frequencies are validated when volumes are created, but records
+			// written by older releases may still hold bad ones.
+			duration, err := volume.Options.Snapshot.Interval()
 			if err != nil {
-				log.Errorf("Runtime configuration incorrect; cannot use %q as a snapshot frequency", volume.Options.Snapshot.Frequency)
-				return
+				log.Errorf("Runtime configuration incorrect; cannot use %q as a snapshot frequency: %v", volume.Options.Snapshot.Frequency, err)
+				continue
 			}
 
-			if volume.Options.UseSnapshots && time.Now().Unix()%int64(duration.Seconds()) == 0 {
+			if time.Now().Unix()%int64(duration.Seconds()) == 0 {
 				action(v.config, volume.Options.Pool, volume)
 			}
 		}
@@ -37,11 +43,11 @@ func scheduleSnapshotPrune(config *config.TopLevelConfig) {
 }
 
 func runSnapshotPrune(config *config.TopLevelConfig, pool string, volume *config.VolumeConfig) {
-	cephVol := cephdriver.NewCephDriver().NewVolume(pool, strings.Join([]string{volume.TenantName, volume.VolumeName}, "."), volume.Options.Size)
+	cephVol := cephdriver.NewCephDriver().NewVolume(pool, volume.ImageName(), volume.Options.Size)
 	log.Debugf("starting snapshot prune for %q", volume.VolumeName)
 	list, err := cephVol.ListSnapshots()
 	if err != nil {
-		log.Errorf("Could not list snapshots for volume %q", volume)
+		log.Errorf("Could not list snapshots for volume %q", volume.VolumeName)
 		return
 	}
 
@@ -51,27 +57,46 @@ func runSnapshotPrune(config *config.TopLevelConfig, pool string, volume *config
 	}
 
 	for i := 0; i < toDeleteCount; i++ {
-		log.Infof("Removing snapshot %q for  volume %q", list[i], volume)
+		log.Infof("Removing snapshot %q for volume %q", list[i], volume.VolumeName)
 		if err := cephVol.RemoveSnapshot(list[i]); err != nil {
-			log.Errorf("Removing snapshot %q for volume %q failed: %v", list[i], volume, err)
+			log.Errorf("Removing snapshot %q for volume %q failed: %v", list[i], volume.VolumeName, err)
 		}
 	}
 }
 
-func runSnapshot(config *config.TopLevelConfig, pool string, volume *config.VolumeConfig) {
-	now := time.Now()
-	cephVol := cephdriver.NewCephDriver().NewVolume(pool, strings.Join([]string{volume.TenantName, volume.VolumeName}, "."), volume.Options.Size)
-	log.Infof("Snapping volume %q at %v", volume, now)
-	if err := cephVol.CreateSnapshot(now.String()); err != nil {
-		log.Errorf("Cannot snap volume: %q: %v", volume.VolumeName, err)
+// runSnapshot returns the snapshot action. Mounted volumes are frozen
+// through the volplugin holding them, which listens on volpluginPort, for the
+// duration of the snapshot.
+func runSnapshot(volpluginPort string) func(*config.TopLevelConfig, string, *config.VolumeConfig) {
+	return func(config *config.TopLevelConfig, pool string, volume *config.VolumeConfig) {
+		now := time.Now()
+		cephVol := cephdriver.NewCephDriver().NewVolume(pool, volume.ImageName(), volume.Options.Size)
+
+		if mt, err := config.GetMount(volume.TenantName, volume.VolumeName); err == nil {
+			host := net.JoinHostPort(mt.Host, volpluginPort)
+			if err := requestFreeze(host, volume); err != nil {
+				log.Warnf("Could not freeze volume %q on host %q, snapshot will only be crash-consistent: %v", volume.VolumeName, mt.Host, err)
+			} else {
+				defer func() {
+					if err := requestThaw(host, volume); err != nil {
+						log.Errorf("Could not thaw volume %q on host %q: %v", volume.VolumeName, mt.Host, err)
+					}
+				}()
+			}
+		}
+
+		log.Infof("Snapping volume %q at %v", volume.VolumeName, now)
+		if err := cephVol.CreateSnapshot(now.String()); err != nil {
+			log.Errorf("Cannot snap volume: %q: %v", volume.VolumeName, err)
+		}
 	}
 }
 
-func scheduleSnapshots(config *config.TopLevelConfig) {
+func scheduleSnapshots(config *config.TopLevelConfig, volpluginPort string) {
 	for {
 		log.Debug("Running snapshot supervisor")
 
-		iterateVolumes(config, wrapSnapshotAction(runSnapshot))
+		iterateVolumes(config, wrapSnapshotAction(runSnapshot(volpluginPort)))
 
 		time.Sleep(1 * time.Second)
 	}
@@ -16,12 +16,14 @@ func start(ctx *cli.Context) {
 		log.Debug("Debug logging enabled")
 	}
 
-	cfg, err := config.NewTopLevelConfig(ctx.String("prefix"), ctx.StringSlice("etcd"))
+	store, err := config.NewStore(ctx.String("etcd-api"), ctx.StringSlice("etcd"))
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	volsupervisor.Daemon(cfg)
+	cfg := config.NewTopLevelConfigWithStore(ctx.String("prefix"), store)
+
+	volsupervisor.Daemon(cfg, ctx.String("volplugin-port"))
 }
 
 func main() {
@@ -41,6 +43,11 @@ func main() {
 			EnvVar: "LISTEN",
 			Value:  ":8080",
 		},
+		cli.StringFlag{
+			Name:  "volplugin-port",
+			Usage: "port of the volplugin control interface, used to freeze volumes for snapshots",
+			Value: "8081",
+		},
 		cli.StringFlag{
 			Name:  "prefix",
 			Usage: "prefix key used in etcd for namespacing",
@@ -51,6 +58,11 @@ func main() {
 			Usage: "URL for etcd",
 			Value: &cli.StringSlice{"http://localhost:2379"},
 		},
+		cli.StringFlag{
+			Name:  "etcd-api",
+			Usage: "etcd API version to use: v2 or v3",
+			Value: "v2",
+		},
 	}
 	app.Run(os.Args)
 }